@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Alert statuses used by Alertmanager
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alerts is a structure for grouping Prometheus Alerts, matching version 4
+// of the Alertmanager webhook payload
+type Alerts struct {
+	Version           string  `json:"version"`
+	GroupKey          string  `json:"groupKey"`
+	TruncatedAlerts   int     `json:"truncatedAlerts"`
+	Status            string  `json:"status"`
+	Receiver          string  `json:"receiver"`
+	GroupLabels       KV      `json:"groupLabels"`
+	CommonLabels      KV      `json:"commonLabels"`
+	CommonAnnotations KV      `json:"commonAnnotations"`
+	ExternalURL       string  `json:"externalURL"`
+	Alerts            []Alert `json:"alerts"`
+}
+
+// Alert is a structure for a single Prometheus Alert
+type Alert struct {
+	Status       string    `json:"status"`
+	Labels       KV        `json:"labels"`
+	Annotations  KV        `json:"annotations"`
+	StartsAt     time.Time `json:"startsAt"`
+	EndsAt       time.Time `json:"endsAt"`
+	GeneratorURL string    `json:"generatorURL"`
+	Fingerprint  string    `json:"fingerprint"`
+}
+
+// Firing returns the subset of alerts that are firing
+func (as Alerts) Firing() []Alert {
+	return as.withStatus(AlertFiring)
+}
+
+// Resolved returns the subset of alerts that are resolved
+func (as Alerts) Resolved() []Alert {
+	return as.withStatus(AlertResolved)
+}
+
+func (as Alerts) withStatus(status string) []Alert {
+	res := []Alert{}
+	for _, a := range as.Alerts {
+		if a.Status == status {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// Firing reports whether the alert is firing
+func (a Alert) Firing() bool {
+	return a.Status == AlertFiring
+}
+
+// Resolved reports whether the alert is resolved
+func (a Alert) Resolved() bool {
+	return a.Status == AlertResolved
+}
+
+// KV is a set of labels or annotations
+type KV map[string]string
+
+// Pair is a key/value string pair
+type Pair struct {
+	Name, Value string
+}
+
+// SortedPairs returns the key/value pairs sorted by name
+func (kv KV) SortedPairs() []Pair {
+	pairs := make([]Pair, 0, len(kv))
+	for _, name := range kv.Names() {
+		pairs = append(pairs, Pair{Name: name, Value: kv[name]})
+	}
+	return pairs
+}
+
+// Names returns the sorted names
+func (kv KV) Names() []string {
+	names := make([]string, 0, len(kv))
+	for name := range kv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Values returns the values sorted by their names
+func (kv KV) Values() []string {
+	values := make([]string, 0, len(kv))
+	for _, name := range kv.Names() {
+		values = append(values, kv[name])
+	}
+	return values
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAlertsUnmarshal(t *testing.T) {
+	var alerts Alerts
+	if err := json.Unmarshal(data, &alerts); err != nil {
+		t.Fatal(err)
+	}
+
+	if alerts.Version != "4" {
+		t.Errorf("Version = %q, want 4", alerts.Version)
+	}
+	if alerts.GroupKey == "" {
+		t.Error("GroupKey was not parsed")
+	}
+	if len(alerts.Alerts) != 1 {
+		t.Fatalf("Parsed %d alerts, want 1", len(alerts.Alerts))
+	}
+
+	alert := alerts.Alerts[0]
+	if !alert.StartsAt.Equal(time.Date(2016, 4, 27, 20, 46, 37, 903000000, time.UTC)) {
+		t.Errorf("StartsAt = %v", alert.StartsAt)
+	}
+	if !alert.EndsAt.IsZero() {
+		t.Errorf("EndsAt = %v, want zero time", alert.EndsAt)
+	}
+	if alert.Fingerprint == "" || !alert.Firing() {
+		t.Error("Alert fingerprint or status was not parsed")
+	}
+}
+
+func TestAlertsFiringResolved(t *testing.T) {
+	alerts := Alerts{
+		Alerts: []Alert{
+			{Status: AlertFiring, Fingerprint: "a"},
+			{Status: AlertResolved, Fingerprint: "b"},
+			{Status: AlertFiring, Fingerprint: "c"},
+		},
+	}
+
+	if got := alerts.Firing(); len(got) != 2 || got[0].Fingerprint != "a" || got[1].Fingerprint != "c" {
+		t.Errorf("Firing() = %v", got)
+	}
+	if got := alerts.Resolved(); len(got) != 1 || got[0].Fingerprint != "b" {
+		t.Errorf("Resolved() = %v", got)
+	}
+}
+
+func TestKVSortedPairs(t *testing.T) {
+	kv := KV{"severity": "critical", "alertname": "Down", "job": "node"}
+
+	pairs := kv.SortedPairs()
+	want := []string{"alertname", "job", "severity"}
+	for i, pair := range pairs {
+		if pair.Name != want[i] || pair.Value != kv[want[i]] {
+			t.Fatalf("SortedPairs() = %v", pairs)
+		}
+	}
+	if values := kv.Values(); values[0] != "Down" || values[2] != "critical" {
+		t.Errorf("Values() = %v", values)
+	}
+}
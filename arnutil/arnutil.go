@@ -38,6 +38,27 @@ func GetRegionFromARN(arnString string) string {
 	return arn.Region
 }
 
+// ReplaceRegion is a helper function to get the ARN of the same resource
+// in another region
+func ReplaceRegion(arnString string, region string) (string, error) {
+	arn, err := arn.Parse(arnString)
+	if err != nil {
+		return "", err
+	}
+	arn.Region = region
+	return arn.String(), nil
+}
+
+// IsFIFOTopic is a helper function to check whether an ARN refers to a
+// FIFO SNS topic
+func IsFIFOTopic(arnString string) bool {
+	arn, err := arn.Parse(arnString)
+	if err != nil {
+		return false
+	}
+	return arn.Service == endpoints.SnsServiceID && strings.HasSuffix(arn.Resource, ".fifo")
+}
+
 // InstanceProfileArn uses the EC2 metadata API to find the role for
 // the instance.
 func InstanceProfileArn(svc *ec2metadata.EC2Metadata) (arn.ARN, error) {
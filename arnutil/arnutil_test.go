@@ -138,3 +138,34 @@ func TestGetRegionFromARN(t *testing.T) {
 		t.Fatal("Region parsed from wrong ARN was not empty")
 	}
 }
+
+func TestReplaceRegion(t *testing.T) {
+
+	replica, err := ReplaceRegion("arn:aws:sns:eu-central-1:123456789012:alerts", "eu-west-1")
+	if err != nil || replica != "arn:aws:sns:eu-west-1:123456789012:alerts" {
+		t.Fatalf("Wrong replica ARN %q: %v", replica, err)
+	}
+
+	if _, err := ReplaceRegion("alerts", "eu-west-1"); err == nil {
+		t.Fatal("Wrong ARN accepted")
+	}
+}
+
+func TestIsFIFOTopic(t *testing.T) {
+
+	if !IsFIFOTopic("arn:aws:sns:eu-central-1:123456789012:alerts.fifo") {
+		t.Fatal("FIFO topic ARN detected as standard topic")
+	}
+
+	if IsFIFOTopic("arn:aws:sns:eu-central-1:123456789012:alerts") {
+		t.Fatal("Standard topic ARN detected as FIFO topic")
+	}
+
+	if IsFIFOTopic("arn:aws:sqs:eu-central-1:123456789012:alerts.fifo") {
+		t.Fatal("SQS queue ARN detected as FIFO topic")
+	}
+
+	if IsFIFOTopic("alerts.fifo") {
+		t.Fatal("Wrong ARN detected as FIFO topic")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a request is rejected as unauthorized for, used as metric label
+const (
+	reasonMissingCredentials = "missing_credentials"
+	reasonInvalidCredentials = "invalid_credentials"
+	reasonUnreadableSecrets  = "unreadable_secrets"
+	reasonMissingClientCert  = "missing_client_certificate"
+)
+
+var (
+	// bearerTokens and basicUsers hold the credentials accepted by
+	// authenticate, the endpoints are open if neither is set
+	bearerTokens *secretFile
+	basicUsers   *secretFile
+
+	unauthorizedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "unauthorized_requests_total",
+			Help:      "Total number of requests rejected for missing or invalid credentials.",
+		},
+		[]string{"reason"},
+	)
+)
+
+// secretFile holds the non-empty lines of a file, lines starting with #
+// are comments. The file is read again when it changes, so secrets mounted
+// from Kubernetes secrets can be rotated without restart.
+type secretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	lines   [][]byte
+}
+
+// newSecretFile reads the file, which has to hold at least one secret
+func newSecretFile(path string) (*secretFile, error) {
+	f := &secretFile{path: path}
+	lines, err := f.values()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s holds no secrets", path)
+	}
+	return f, nil
+}
+
+// values returns the lines of the file, reading it again if it changed
+func (f *secretFile) values() ([][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.lines != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.lines, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		lines = append(lines, append([]byte(nil), line...))
+	}
+
+	if f.lines != nil {
+		log.Infof("Reloaded %d secrets from %s", len(lines), f.path)
+	}
+	f.lines, f.modTime, f.size = lines, info.ModTime(), info.Size()
+
+	return f.lines, nil
+}
+
+// contains reports whether the file holds the secret. All lines are
+// compared in constant time, so the secrets do not leak through timing.
+func (f *secretFile) contains(secret []byte) (bool, error) {
+	lines, err := f.values()
+	if err != nil {
+		return false, err
+	}
+
+	digest := sha256.Sum256(secret)
+	found := 0
+	for _, line := range lines {
+		lineDigest := sha256.Sum256(line)
+		found |= subtle.ConstantTimeCompare(digest[:], lineDigest[:])
+	}
+	return found == 1, nil
+}
+
+// authenticate is a middleware rejecting requests without a bearer token
+// or basic auth credentials, given as username:password line, held by the
+// configured files
+func authenticate(c *gin.Context) {
+	if bearerTokens == nil && basicUsers == nil {
+		c.Next()
+		return
+	}
+
+	var ok bool
+	var err error
+	header := c.GetHeader("Authorization")
+	switch {
+	case bearerTokens != nil && strings.HasPrefix(header, "Bearer "):
+		ok, err = bearerTokens.contains([]byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))))
+	case basicUsers != nil && strings.HasPrefix(header, "Basic "):
+		username, password, valid := c.Request.BasicAuth()
+		if valid {
+			ok, err = basicUsers.contains([]byte(username + ":" + password))
+		}
+	default:
+		unauthorized(c, reasonMissingCredentials)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Cannot read credentials: %v", err)
+		unauthorizedRequests.WithLabelValues(reasonUnreadableSecrets).Inc()
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		unauthorized(c, reasonInvalidCredentials)
+		return
+	}
+
+	c.Next()
+}
+
+// unauthorized responds with 401 and the schemes accepted
+func unauthorized(c *gin.Context, reason string) {
+	unauthorizedRequests.WithLabelValues(reason).Inc()
+	log.Warnf("Rejecting request to %s from %s: %s", c.Request.URL.Path, c.ClientIP(), strings.Replace(reason, "_", " ", -1))
+
+	if basicUsers != nil {
+		c.Header("WWW-Authenticate", `Basic realm="alertmanager-sns-forwarder"`)
+	}
+	if bearerTokens != nil {
+		c.Writer.Header().Add("WWW-Authenticate", `Bearer realm="alertmanager-sns-forwarder"`)
+	}
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuthenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "tokens")
+	usersFile := filepath.Join(dir, "users")
+	ioutil.WriteFile(tokenFile, []byte("# webhook tokens\nfirst-token\n"), 0600)
+	ioutil.WriteFile(usersFile, []byte("alertmanager:secret\n"), 0600)
+
+	if bearerTokens, err = newSecretFile(tokenFile); err != nil {
+		t.Fatal(err)
+	}
+	if basicUsers, err = newSecretFile(usersFile); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { bearerTokens, basicUsers = nil, nil }()
+
+	svc = sns.New(mockJsonDataSession)
+	arnPrefixCorrectTemp := "arn:aws:sns:eu-central-1:123456789012:"
+	arnPrefix = &arnPrefixCorrectTemp
+
+	request := func(header string) *http.Request {
+		req, _ := http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"Bearer token", "Bearer first-token", http.StatusOK},
+		{"Basic auth", "Basic YWxlcnRtYW5hZ2VyOnNlY3JldA==", http.StatusOK},
+		{"Missing credentials", "", http.StatusUnauthorized},
+		{"Invalid token", "Bearer second-token", http.StatusUnauthorized},
+		{"Invalid password", "Basic YWxlcnRtYW5hZ2VyOnNlY3JldDI=", http.StatusUnauthorized},
+		{"Comment as token", "Bearer # webhook tokens", http.StatusUnauthorized},
+	}
+	missing := testutil.ToFloat64(unauthorizedRequests.WithLabelValues(reasonMissingCredentials))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHTTPResponse(t, r, request(tt.header), tt.want)
+		})
+	}
+	if got := testutil.ToFloat64(unauthorizedRequests.WithLabelValues(reasonMissingCredentials)) - missing; got != 1 {
+		t.Errorf("requests missing credentials = %v, want 1", got)
+	}
+
+	// Test that the health endpoint stays open
+	req, _ := http.NewRequest("GET", "/health", nil)
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	// Test that rotated tokens are used without restart
+	ioutil.WriteFile(tokenFile, []byte("second-token\n"), 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tokenFile, later, later)
+	testHTTPResponse(t, r, request("Bearer second-token"), http.StatusOK)
+	testHTTPResponse(t, r, request("Bearer first-token"), http.StatusUnauthorized)
+
+	// Test that requests are rejected while the tokens cannot be read
+	os.Remove(tokenFile)
+	testHTTPResponse(t, r, request("Bearer second-token"), http.StatusServiceUnavailable)
+}
+
+func TestNewSecretFile(t *testing.T) {
+	file, _ := ioutil.TempFile("", "secrets")
+	file.WriteString("# no secrets yet\n\n")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if _, err := newSecretFile(file.Name()); err == nil {
+		t.Error("File without secrets was accepted")
+	}
+	if _, err := newSecretFile(file.Name() + ".missing"); err == nil {
+		t.Error("Missing file was accepted")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// maxBatchEntries is the number of messages SNS and SQS accept in a batch
+// request
+const maxBatchEntries = 10
+
+// alertInput is the publish request of a single alert
+type alertInput struct {
+	alert  Alert
+	params *sns.PublishInput
+}
+
+// failedAlert reports an alert whose message could not be published
+type failedAlert struct {
+	Topic       string `json:"topic"`
+	Fingerprint string `json:"fingerprint"`
+	Alertname   string `json:"alertname,omitempty"`
+	Code        string `json:"code"`
+	Error       string `json:"error"`
+}
+
+func newFailedAlert(topic string, alert Alert, code string, message string) failedAlert {
+	log.Warnf("Alert %s (%s) could not be published to topic %s: %s: %s", alert.Fingerprint, alert.Labels["alertname"], topic, code, message)
+	return failedAlert{
+		Topic:       topic,
+		Fingerprint: alert.Fingerprint,
+		Alertname:   alert.Labels["alertname"],
+		Code:        code,
+		Error:       message,
+	}
+}
+
+// singleAlert returns the payload of a notification for one alert of the
+// group, with the labels and annotations of the alert being the common ones
+func singleAlert(alerts Alerts, alert Alert) Alerts {
+	single := alerts
+	single.Status = alert.Status
+	single.CommonLabels = alert.Labels
+	single.CommonAnnotations = alert.Annotations
+	single.TruncatedAlerts = 0
+	single.Alerts = []Alert{alert}
+	return single
+}
+
+// alertInputs builds a publish request for every alert. Alerts whose
+// message cannot be built are reported as failed.
+func (t *Target) alertInputs(tmpl *template.Template, alerts Alerts) ([]alertInput, []failedAlert, int) {
+	var inputs []alertInput
+	var failed []failedAlert
+	status := http.StatusOK
+
+	for _, alert := range alerts.Alerts {
+		single := singleAlert(alerts, alert)
+
+		requestData, err := json.Marshal(single)
+		if err == nil {
+			var params []*sns.PublishInput
+			params, err = t.publishInputs(tmpl, single, requestData)
+			for _, p := range params {
+				inputs = append(inputs, alertInput{alert: alert, params: p})
+			}
+		}
+		if err != nil {
+			code, reason := http.StatusInternalServerError, "InvalidMessage"
+			if err == errMessageTooLarge {
+				code, reason = http.StatusBadRequest, "MessageTooLarge"
+			}
+			if code > status {
+				status = code
+			}
+			failed = append(failed, newFailedAlert(t.topicName(), alert, reason, err.Error()))
+		}
+	}
+
+	return inputs, failed, status
+}
+
+// batchNotifier is implemented by notifiers delivering several messages
+// with a single request
+type batchNotifier interface {
+	// PublishBatch delivers the messages and returns those that failed. An
+	// error is returned if the request as a whole failed.
+	PublishBatch(d delivery, batch []*sns.PublishInput) ([]batchFailure, error)
+}
+
+// batchFailure is a message of a batch that failed
+type batchFailure struct {
+	index       int
+	code        string
+	message     string
+	senderFault bool
+}
+
+func newBatchFailure(id, code, message *string, senderFault *bool, size int) batchFailure {
+	i, err := strconv.Atoi(aws.StringValue(id))
+	if err != nil || i < 0 || i >= size {
+		log.Errorf("Batch request returned unknown entry ID %q", aws.StringValue(id))
+		i = -1
+	}
+
+	return batchFailure{
+		index:       i,
+		code:        aws.StringValue(code),
+		message:     aws.StringValue(message),
+		senderFault: aws.BoolValue(senderFault),
+	}
+}
+
+// sequentialBatch publishes the messages of a batch one by one, for
+// notifiers without batch requests
+type sequentialBatch struct {
+	Notifier
+}
+
+// PublishBatch implements batchNotifier
+func (n sequentialBatch) PublishBatch(d delivery, batch []*sns.PublishInput) ([]batchFailure, error) {
+	var failures []batchFailure
+
+	for i, params := range batch {
+		err := n.Publish(d, params)
+		if err == nil {
+			continue
+		}
+
+		code := "PublishError"
+		if aerr, ok := err.(awserr.Error); ok {
+			code = aerr.Code()
+		}
+		failures = append(failures, batchFailure{
+			index:       i,
+			code:        code,
+			message:     err.Error(),
+			senderFault: n.StatusCode(err) < http.StatusInternalServerError,
+		})
+	}
+
+	return failures, nil
+}
+
+// publishAlerts publishes one message per alert of the notification with
+// batch requests and returns the HTTP status code to report back to
+// Alertmanager, together with the alerts that failed
+func publishAlerts(target *Target, tmpl *template.Template, alerts Alerts) (int, []failedAlert) {
+	inputs, failed, status := target.alertInputs(tmpl, alerts)
+
+	for _, batch := range batches(inputs) {
+		code, batchFailed := publishBatch(target.delivery(), batch, target.replicas())
+		if code > status {
+			status = code
+		}
+		failed = append(failed, batchFailed...)
+	}
+
+	return status, failed
+}
+
+// batches groups the requests into batches SNS and SQS accept, of at most
+// maxBatchEntries messages with a total size of at most maxMessageSize
+func batches(inputs []alertInput) [][]alertInput {
+	var result [][]alertInput
+	var batch []alertInput
+	size := 0
+
+	for _, in := range inputs {
+		n := messageSize(in.params)
+		if len(batch) > 0 && (len(batch) == maxBatchEntries || size+n > maxMessageSize) {
+			result = append(result, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, in)
+		size += n
+	}
+	if len(batch) > 0 {
+		result = append(result, batch)
+	}
+
+	return result
+}
+
+// publishBatch delivers a batch of messages. Messages failing transiently
+// fail over to the replicas one by one and are queued for retry like
+// single publishes.
+func publishBatch(d delivery, batch []alertInput, replicas []replica) (int, []failedAlert) {
+	params := make([]*sns.PublishInput, 0, len(batch))
+	for _, in := range batch {
+		params = append(params, in.params)
+	}
+
+	n, ok := d.notifier().(batchNotifier)
+	if !ok {
+		n = sequentialBatch{d.notifier()}
+	}
+
+	log.Debugf("Publishing batch of %d messages to %s %s", len(params), d.backend(), d.Topic)
+
+	failures, err := n.PublishBatch(d, params)
+
+	status := http.StatusOK
+	var failed []failedAlert
+
+	if err != nil {
+		log.Warn(err.Error())
+		code, reason := d.statusCode(err), "RequestError"
+		if aerr, ok := err.(awserr.Error); ok {
+			reason = aerr.Code()
+		}
+
+		for _, in := range batch {
+			c := failover(d, in.params, err, code, replicas)
+			if c >= http.StatusBadRequest {
+				failed = append(failed, newFailedAlert(d.Topic, in.alert, reason, err.Error()))
+			}
+			if c > status {
+				status = c
+			}
+		}
+		return status, failed
+	}
+
+	published(d, publishRegion(d.Client, params[0]), len(batch)-len(failures))
+
+	for _, f := range failures {
+		if f.index < 0 {
+			continue
+		}
+		in := batch[f.index]
+
+		cause := awserr.New(f.code, f.message, nil)
+		code := d.statusCode(cause)
+		if f.senderFault && code >= http.StatusInternalServerError {
+			code = http.StatusBadRequest
+		}
+
+		c := failover(d, in.params, cause, code, replicas)
+		if c >= http.StatusBadRequest {
+			failed = append(failed, newFailedAlert(d.Topic, in.alert, f.code, f.message))
+		}
+		if c > status {
+			status = c
+		}
+	}
+
+	return status, failed
+}
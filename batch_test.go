@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// makeMockBatchSNS returns an SNS client whose PublishBatch requests fail
+// for the entry with the given ID, recording the number of entries of every
+// request
+func makeMockBatchSNS(failID string, sizes *[]int) *sns.SNS {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		n := 0
+		for key := range r.PostForm {
+			if strings.HasSuffix(key, ".Id") {
+				n++
+			}
+		}
+		*sizes = append(*sizes, n)
+
+		var successful, failed strings.Builder
+		for i := 0; i < n; i++ {
+			id := fmt.Sprint(i)
+			if id == failID {
+				fmt.Fprintf(&failed, "<member><Id>%s</Id><Code>InvalidParameter</Code><Message>invalid message</Message><SenderFault>true</SenderFault></member>", id)
+				continue
+			}
+			fmt.Fprintf(&successful, "<member><Id>%s</Id><MessageId>message-%s</MessageId></member>", id, id)
+		}
+
+		fmt.Fprintf(w, "<PublishBatchResponse><PublishBatchResult><Successful>%s</Successful><Failed>%s</Failed></PublishBatchResult></PublishBatchResponse>",
+			successful.String(), failed.String())
+	}))
+
+	return sns.New(session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	})))
+}
+
+func TestPublishAlerts(t *testing.T) {
+	alerts := testAlerts(t)
+	for i := 1; i < 12; i++ {
+		alert := alerts.Alerts[0]
+		alert.Fingerprint = fmt.Sprintf("fingerprint%02d", i)
+		alert.Labels = KV{"alertname": "InstanceDown", "instance": fmt.Sprintf("server%02d", i)}
+		alerts.Alerts = append(alerts.Alerts, alert)
+	}
+
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts",
+		Subject:  "{{ .CommonLabels.instance }}",
+		PerAlert: true,
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	svc = makeMockBatchSNS("1", &sizes)
+
+	status, failed := publishAlerts(target, nil, alerts)
+
+	if fmt.Sprint(sizes) != "[10 2]" {
+		t.Errorf("batch sizes = %v, want [10 2]", sizes)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("got %d failed alerts, want 2", len(failed))
+	}
+	if failed[0].Fingerprint != alerts.Alerts[1].Fingerprint || failed[1].Fingerprint != alerts.Alerts[11].Fingerprint {
+		t.Errorf("failed alerts = %+v", failed)
+	}
+	if failed[0].Code != "InvalidParameter" {
+		t.Errorf("failed alert code = %q, want InvalidParameter", failed[0].Code)
+	}
+}
+
+func TestAlertInputs(t *testing.T) {
+	alerts := testAlerts(t)
+	alerts.Alerts = append(alerts.Alerts, alerts.Alerts[0])
+	alerts.Alerts[1].Status = AlertResolved
+	alerts.Alerts[1].Fingerprint = "resolved"
+	alerts.Alerts[1].Labels = KV{"alertname": "InstanceDown", "instance": "server02"}
+
+	target := &Target{
+		TopicARN:          "arn:aws:sns:eu-central-1:123456789012:alerts.fifo",
+		Subject:           "[{{ .Status }}] {{ .CommonLabels.instance }}",
+		MessageAttributes: []*MessageAttribute{{Name: "instance", Label: "instance"}},
+		PerAlert:          true,
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, failed, _ := target.alertInputs(nil, alerts)
+	if len(failed) != 0 || len(inputs) != 2 {
+		t.Fatalf("got %d inputs and %d failed alerts, want 2 and 0", len(inputs), len(failed))
+	}
+
+	params := inputs[1].params
+	if got := aws.StringValue(params.Subject); got != "[resolved] server02" {
+		t.Errorf("subject = %q, want %q", got, "[resolved] server02")
+	}
+	if got := aws.StringValue(params.MessageAttributes["instance"].StringValue); got != "server02" {
+		t.Errorf("instance attribute = %q, want server02", got)
+	}
+	if aws.StringValue(inputs[0].params.MessageDeduplicationId) == aws.StringValue(params.MessageDeduplicationId) {
+		t.Error("alerts share a deduplication ID")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roleSessionName identifies the forwarder in CloudTrail logs of assumed roles
+const roleSessionName = "alertmanager-sns-forwarder"
+
+var (
+	externalIDRE = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+
+	// awsSession is the session of the forwarder's own credentials
+	awsSession *session.Session
+
+	clientsMu       sync.Mutex
+	clients         = make(map[serviceKey]interface{})
+	roleCredentials = make(map[clientKey]*credentials.Credentials)
+
+	assumeRoleFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sts",
+			Name:      "assume_role_failures_total",
+			Help:      "Total number of failed attempts to assume a role for publishing.",
+		},
+		[]string{"role"},
+	)
+)
+
+// clientKey identifies the client a message is published with. The zero
+// value is the client using the forwarder's own credentials in the
+// region of the session.
+type clientKey struct {
+	RoleARN    string `json:"roleArn,omitempty"`
+	ExternalID string `json:"externalId,omitempty"`
+	Region     string `json:"region,omitempty"`
+}
+
+// validateRole checks the role ARN and external ID of a target
+func validateRole(roleARN, externalID string) error {
+	if roleARN == "" {
+		if externalID != "" {
+			return fmt.Errorf("external_id needs a role_arn")
+		}
+		return nil
+	}
+
+	parsed, err := arn.Parse(roleARN)
+	if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return fmt.Errorf("invalid role_arn %q", roleARN)
+	}
+	if externalID != "" && (len(externalID) < 2 || len(externalID) > 1224 || !externalIDRE.MatchString(externalID)) {
+		return fmt.Errorf("invalid external_id")
+	}
+
+	return nil
+}
+
+// serviceKey identifies a cached client of a backend service
+type serviceKey struct {
+	service string
+	key     clientKey
+}
+
+// client returns the client of the service for the key, building it with
+// build and caching it on first use. The credentials of assumed roles are
+// shared by the clients of all services and regions; they are kept and
+// refreshed before they expire.
+func client(service string, key clientKey, build func(*aws.Config) interface{}) interface{} {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if c, ok := clients[serviceKey{service, key}]; ok {
+		return c
+	}
+
+	config := aws.NewConfig()
+	if key.Region != "" {
+		config.WithRegion(key.Region)
+	}
+	if key.RoleARN != "" {
+		role := clientKey{RoleARN: key.RoleARN, ExternalID: key.ExternalID}
+		creds, ok := roleCredentials[role]
+		if !ok {
+			creds = assumeRole(role)
+			roleCredentials[role] = creds
+		}
+		config.WithCredentials(creds)
+	}
+
+	c := build(config)
+	clients[serviceKey{service, key}] = c
+
+	return c
+}
+
+// snsClient returns the SNS client for the key
+func snsClient(key clientKey) *sns.SNS {
+	if key == (clientKey{}) {
+		return svc
+	}
+
+	return client(sns.ServiceName, key, func(config *aws.Config) interface{} {
+		return sns.New(awsSession, config)
+	}).(*sns.SNS)
+}
+
+// assumeRole returns the credentials of the role of the key
+func assumeRole(key clientKey) *credentials.Credentials {
+	provider := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(awsSession),
+		RoleARN:         key.RoleARN,
+		RoleSessionName: roleSessionName,
+		Duration:        stscreds.DefaultDuration,
+		ExpiryWindow:    stscreds.DefaultDuration / 10,
+	}
+	if key.ExternalID != "" {
+		provider.ExternalID = &key.ExternalID
+	}
+
+	return credentials.NewCredentials(&assumeRoleProvider{AssumeRoleProvider: provider})
+}
+
+// assumeRoleProvider counts the failures of assuming a role
+type assumeRoleProvider struct {
+	*stscreds.AssumeRoleProvider
+}
+
+// Retrieve implements credentials.Provider
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+// RetrieveWithContext implements credentials.ProviderWithContext
+func (p *assumeRoleProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	value, err := p.AssumeRoleProvider.RetrieveWithContext(ctx)
+	if err != nil {
+		assumeRoleFailures.WithLabelValues(p.RoleARN).Inc()
+		log.Errorf("Cannot assume role %s: %v", p.RoleARN, err)
+	}
+	return value, err
+}
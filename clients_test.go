@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeMockSTSSession returns a Session whose AssumeRole requests succeed
+// and whose other requests return OK without data
+func makeMockSTSSession() *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("Action") != "AssumeRole" {
+			return
+		}
+
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ASSUMED</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey>
+<SessionToken>TOKEN</SessionToken><Expiration>%s</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	}))
+}
+
+// resetClients drops the cached clients and credentials
+func resetClients() {
+	clients = make(map[serviceKey]interface{})
+	roleCredentials = make(map[clientKey]*credentials.Credentials)
+}
+
+func TestValidateRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		roleARN    string
+		externalID string
+		valid      bool
+	}{
+		{"No role", "", "", true},
+		{"Role", "arn:aws:iam::210987654321:role/alert-publisher", "", true},
+		{"Role with external ID", "arn:aws:iam::210987654321:role/alert-publisher", "alertmanager", true},
+		{"External ID without role", "", "alertmanager", false},
+		{"Not an ARN", "alert-publisher", "", false},
+		{"Not a role", "arn:aws:iam::210987654321:user/alert-publisher", "", false},
+		{"Invalid external ID", "arn:aws:iam::210987654321:role/alert-publisher", "alert manager", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRole(tt.roleARN, tt.externalID); (err == nil) != tt.valid {
+				t.Errorf("validateRole() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRouteRole(t *testing.T) {
+	config, err := loadConfig("testdata/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that targets inherit the role of their route
+	want := clientKey{RoleARN: "arn:aws:iam::210987654321:role/alert-publisher", ExternalID: "alertmanager"}
+	if got := config.Routes[1].Targets[0].client(); got != want {
+		t.Errorf("client() = %+v, want %+v", got, want)
+	}
+	if got := config.Routes[0].Targets[0].client(); got != (clientKey{}) {
+		t.Errorf("client() = %+v, want own credentials", got)
+	}
+}
+
+func TestSNSClient(t *testing.T) {
+	awsSession = mockUnavailableSession
+	svc = sns.New(mockUnavailableSession)
+	resetClients()
+
+	// Test that the own credentials use the global client
+	if snsClient(clientKey{}) != svc {
+		t.Error("Own credentials do not use the global client")
+	}
+
+	// Test that clients are cached per role and external ID
+	key := clientKey{RoleARN: "arn:aws:iam::210987654321:role/alert-publisher"}
+	client := snsClient(key)
+	if client == svc || snsClient(key) != client {
+		t.Error("Client of role is not cached")
+	}
+	if snsClient(clientKey{RoleARN: key.RoleARN, ExternalID: "other"}) == client {
+		t.Error("Clients of different external IDs are shared")
+	}
+
+	// Test that failing to assume the role fails the publish and is counted
+	before := testutil.ToFloat64(assumeRoleFailures.WithLabelValues(key.RoleARN))
+	_, err := client.Publish(&sns.PublishInput{
+		Message:  aws.String("test"),
+		TopicArn: aws.String("arn:aws:sns:eu-central-1:210987654321:alerts"),
+	})
+	if err == nil {
+		t.Fatal("Publish succeeded without credentials")
+	}
+	if got := testutil.ToFloat64(assumeRoleFailures.WithLabelValues(key.RoleARN)) - before; got != 1 {
+		t.Errorf("assume role failures = %v, want 1", got)
+	}
+
+	// Test that publishing with an assumed role succeeds
+	awsSession = makeMockSTSSession()
+	resetClients()
+	if code := publish(delivery{Topic: "alerts", Client: key}, &sns.PublishInput{
+		Message:  aws.String("test"),
+		TopicArn: aws.String("arn:aws:sns:eu-central-1:210987654321:alerts"),
+	}, nil); code != http.StatusOK {
+		t.Errorf("publish() = %d, want %d", code, http.StatusOK)
+	}
+}
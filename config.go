@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the routing configuration loaded from the config file
+type Config struct {
+	// Defaults holds the target options used for the topic in the URL
+	Defaults *Target  `yaml:"defaults"`
+	Routes   []*Route `yaml:"routes"`
+
+	// Archive holds targets every notification is delivered to in
+	// addition to the routed ones
+	Archive []*Target `yaml:"archive"`
+}
+
+// Route matches notifications and fans them out to its targets. Routes form
+// a tree evaluated like the Alertmanager route tree: the first matching
+// route of a level wins unless it sets continue, and a matching child route
+// takes precedence over its parent. The role, failover and rate limit of a
+// route apply to its targets and those of its children, unless they set
+// their own. A rate limit applies to each target separately.
+type Route struct {
+	Receiver   string            `yaml:"receiver"`
+	ReceiverRE *Regexp           `yaml:"receiver_re"`
+	Match      map[string]string `yaml:"match"`
+	MatchRE    map[string]Regexp `yaml:"match_re"`
+	Continue   bool              `yaml:"continue"`
+	RoleARN    string            `yaml:"role_arn"`
+	ExternalID string            `yaml:"external_id"`
+	Failover   []string          `yaml:"failover"`
+	RateLimit  *RateLimit        `yaml:"rate_limit"`
+	Targets    []*Target         `yaml:"targets"`
+	Routes     []*Route          `yaml:"routes"`
+}
+
+// Target is an SNS topic, SQS queue, EventBridge event bus, Kinesis stream
+// or Firehose delivery stream notifications are published to, a Lambda
+// function invoked with them, or a list of phone numbers text messages are
+// sent to directly
+type Target struct {
+	TopicARN          string              `yaml:"topic_arn"`
+	QueueURL          string              `yaml:"queue_url"`
+	Template          string              `yaml:"template"`
+	Subject           string              `yaml:"subject"`
+	MessageAttributes []*MessageAttribute `yaml:"message_attributes"`
+	MessageGroupID    string              `yaml:"message_group_id"`
+	DeduplicationID   string              `yaml:"deduplication_id"`
+	MessageStructure  map[string]string   `yaml:"message_structure"`
+	Oversize          string              `yaml:"oversize"`
+	MaxMessageSize    int                 `yaml:"max_message_size"`
+	S3                *S3Offload          `yaml:"s3"`
+	SMS               *SMS                `yaml:"sms"`
+	EventBridge       *EventBridge        `yaml:"eventbridge"`
+	Kinesis           *Kinesis            `yaml:"kinesis"`
+	Firehose          *Firehose           `yaml:"firehose"`
+	Lambda            *Lambda             `yaml:"lambda"`
+	PerAlert          bool                `yaml:"per_alert"`
+	RoleARN           string              `yaml:"role_arn"`
+	ExternalID        string              `yaml:"external_id"`
+	Failover          []string            `yaml:"failover"`
+	RateLimit         *RateLimit          `yaml:"rate_limit"`
+	Digest            *Digest             `yaml:"digest"`
+
+	tmpl             *template.Template
+	protocolTmpls    map[string]*template.Template
+	subjectTmpl      *texttemplate.Template
+	groupIDTmpl      *texttemplate.Template
+	dedupIDTmpl      *texttemplate.Template
+	partitionKeyTmpl *texttemplate.Template
+	digestTmpl       *template.Template
+	label            string
+	// name is the place of the target in the configuration
+	name string
+}
+
+// Regexp is an anchored regular expression unmarshalled from YAML
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	compiled, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return err
+	}
+
+	re.Regexp = compiled
+	re.original = s
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	return re.original, nil
+}
+
+// Duration is a time.Duration unmarshalled from YAML strings like "30s"
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// loadConfig reads and validates the config file, parsing all templates it
+// refers to
+func loadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %s: %v", file, err)
+	}
+
+	if config.Defaults != nil {
+		if config.Defaults.TopicARN != "" || config.Defaults.backend() != backendSNS || config.Defaults.SMS != nil {
+			return nil, fmt.Errorf("defaults: topic_arn is taken from the URL, other destinations cannot be set")
+		}
+		config.Defaults.name = "defaults"
+		if err := config.Defaults.init(); err != nil {
+			return nil, fmt.Errorf("defaults: %v", err)
+		}
+	}
+
+	for i, route := range config.Routes {
+		if err := route.init(nil, fmt.Sprintf("routes[%d]", i)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, target := range config.Archive {
+		target.name = fmt.Sprintf("archive[%d]", i)
+		if err := target.validateDestination(); err != nil {
+			return nil, fmt.Errorf("archive[%d]: %v", i, err)
+		}
+		if err := target.init(); err != nil {
+			return nil, fmt.Errorf("archive[%d]: %v", i, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// init validates the route and its children. Routes without targets inherit
+// the targets of their parent.
+func (r *Route) init(parent *Route, name string) error {
+	if r.RoleARN == "" && parent != nil {
+		r.RoleARN, r.ExternalID = parent.RoleARN, parent.ExternalID
+	}
+	if len(r.Failover) == 0 && parent != nil {
+		r.Failover = parent.Failover
+	}
+	if r.RateLimit == nil && parent != nil {
+		r.RateLimit = parent.RateLimit
+	}
+
+	for i, target := range r.Targets {
+		if target.RoleARN == "" && r.RoleARN != "" {
+			target.RoleARN = r.RoleARN
+			if target.ExternalID == "" {
+				target.ExternalID = r.ExternalID
+			}
+		}
+		// failover replicas are topics, so only topic targets inherit them
+		if len(target.Failover) == 0 && target.TopicARN != "" {
+			target.Failover = r.Failover
+		}
+		if target.RateLimit == nil && r.RateLimit != nil {
+			// every target gets its own copy and thus its own limiter
+			limit := *r.RateLimit
+			target.RateLimit = &limit
+		}
+		target.name = fmt.Sprintf("%s.targets[%d]", name, i)
+		if err := target.validateDestination(); err != nil {
+			return fmt.Errorf("%s.targets[%d]: %v", name, i, err)
+		}
+		if err := target.init(); err != nil {
+			return fmt.Errorf("%s.targets[%d]: %v", name, i, err)
+		}
+	}
+
+	if len(r.Targets) == 0 && parent != nil {
+		r.Targets = parent.Targets
+	}
+
+	for i, child := range r.Routes {
+		if err := child.init(r, fmt.Sprintf("%s.routes[%d]", name, i)); err != nil {
+			return err
+		}
+	}
+
+	if len(r.Targets) == 0 && len(r.Routes) == 0 {
+		return fmt.Errorf("%s: route has neither targets nor routes", name)
+	}
+
+	return nil
+}
+
+// init validates the target options and parses its template
+func (t *Target) init() error {
+	if err := t.initBackend(); err != nil {
+		return err
+	}
+	if t.SMS != nil {
+		if err := t.SMS.validate(); err != nil {
+			return err
+		}
+		t.label = smsMetricLabel
+	}
+	if err := validateRole(t.RoleARN, t.ExternalID); err != nil {
+		return err
+	}
+	if t.PerAlert && t.SMS != nil {
+		return fmt.Errorf("per_alert is not supported for sms targets")
+	}
+
+	if t.Template != "" {
+		tmpl, err := parseTemplate(t.Template)
+		if err != nil {
+			return err
+		}
+		t.tmpl = tmpl
+	}
+
+	if t.Subject != "" {
+		tmpl, err := parseInlineTemplate("subject", t.Subject)
+		if err != nil {
+			return err
+		}
+		t.subjectTmpl = tmpl
+	}
+	if t.MessageGroupID != "" {
+		tmpl, err := parseInlineTemplate("message_group_id", t.MessageGroupID)
+		if err != nil {
+			return err
+		}
+		t.groupIDTmpl = tmpl
+	}
+	if t.DeduplicationID != "" {
+		tmpl, err := parseInlineTemplate("deduplication_id", t.DeduplicationID)
+		if err != nil {
+			return err
+		}
+		t.dedupIDTmpl = tmpl
+	}
+
+	if err := t.initMessageStructure(); err != nil {
+		return err
+	}
+	if err := t.initOversize(); err != nil {
+		return err
+	}
+	if err := t.initFailover(); err != nil {
+		return err
+	}
+	if err := t.initRateLimit(); err != nil {
+		return err
+	}
+	if err := t.initDigest(); err != nil {
+		return err
+	}
+
+	if len(t.MessageAttributes) > maxMessageAttributes {
+		return fmt.Errorf("%d message attributes exceed the SNS limit of %d", len(t.MessageAttributes), maxMessageAttributes)
+	}
+	names := make(map[string]bool)
+	for _, a := range t.MessageAttributes {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if names[a.Name] {
+			return fmt.Errorf("duplicate message attribute %q", a.Name)
+		}
+		names[a.Name] = true
+	}
+
+	return nil
+}
+
+// fallbackTarget returns the target for a topic given in the URL, using the
+// configured defaults
+func fallbackTarget(config *Config, topic string) *Target {
+	target := &Target{}
+	if config != nil && config.Defaults != nil {
+		defaults := *config.Defaults
+		target = &defaults
+	}
+
+	target.TopicARN = *arnPrefix + topic
+	target.label = topic
+
+	return target
+}
+
+// Targets returns the targets of all routes matching the alerts
+func (c *Config) Targets(alerts *Alerts) []*Target {
+	labels := make(map[string]string)
+	for k, v := range alerts.CommonLabels {
+		labels[k] = v
+	}
+	for k, v := range alerts.GroupLabels {
+		labels[k] = v
+	}
+
+	return matchRoutes(c.Routes, alerts.Receiver, labels)
+}
+
+func matchRoutes(routes []*Route, receiver string, labels map[string]string) []*Target {
+	var targets []*Target
+
+	for _, route := range routes {
+		if !route.matches(receiver, labels) {
+			continue
+		}
+
+		matched := matchRoutes(route.Routes, receiver, labels)
+		if len(matched) == 0 {
+			matched = route.Targets
+		}
+		targets = append(targets, matched...)
+
+		if !route.Continue {
+			break
+		}
+	}
+
+	return targets
+}
+
+func (r *Route) matches(receiver string, labels map[string]string) bool {
+	if r.Receiver != "" && r.Receiver != receiver {
+		return false
+	}
+	if r.ReceiverRE != nil && !r.ReceiverRE.MatchString(receiver) {
+		return false
+	}
+
+	for name, value := range r.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	for name, re := range r.MatchRE {
+		if !re.MatchString(labels[name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// client returns the key of the SNS client publishing to the target
+func (t *Target) client() clientKey {
+	key := clientKey{RoleARN: t.RoleARN, ExternalID: t.ExternalID, Region: queueRegion(t.QueueURL)}
+	switch {
+	case t.EventBridge != nil:
+		key.Region = t.EventBridge.eventBusRegion()
+	case t.Lambda != nil:
+		key.Region = t.Lambda.functionRegion()
+	}
+	return key
+}
+
+// topicName returns the name of the topic, queue or stream, used as metric
+// label
+func (t *Target) topicName() string {
+	if t.label != "" {
+		return t.label
+	}
+	if t.QueueURL != "" {
+		return path.Base(t.QueueURL)
+	}
+	if t.EventBridge != nil {
+		return t.EventBridge.eventBusName()
+	}
+	if t.Kinesis != nil {
+		return t.Kinesis.Stream
+	}
+	if t.Firehose != nil {
+		return t.Firehose.DeliveryStream
+	}
+	if t.Lambda != nil {
+		return t.Lambda.functionName()
+	}
+	return t.TopicARN[strings.LastIndex(t.TopicARN, ":")+1:]
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func topicNames(targets []*Target) []string {
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.topicName())
+	}
+	return names
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := loadConfig("testdata/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Routes[0].Targets[0].tmpl == nil {
+		t.Fatal("Target template was not parsed")
+	}
+
+	// Test that a child route without targets inherits those of its parent
+	if len(config.Routes[0].Routes[0].Targets) != 2 {
+		t.Fatal("Child route targets were not kept")
+	}
+
+	// Test that invalid configuration is rejected
+	invalid := []string{
+		"routes:\n- targets:\n  - topic_arn: not-an-arn\n",
+		"routes:\n- match_re:\n    severity: \"(\"\n  targets:\n  - topic_arn: arn:aws:sns:eu-central-1:123456789012:t\n",
+		"routes:\n- match:\n    severity: critical\n",
+		"routes:\n- unknown_field: true\n",
+		"archive:\n- kinesis:\n    stream: not a stream\n",
+	}
+	for _, content := range invalid {
+		file, _ := ioutil.TempFile("", "config")
+		file.WriteString(content)
+		file.Close()
+		defer os.Remove(file.Name())
+
+		if _, err := loadConfig(file.Name()); err == nil {
+			t.Errorf("Invalid config was loaded successfully: %q", content)
+		}
+	}
+}
+
+func TestConfigTargets(t *testing.T) {
+	config, err := loadConfig("testdata/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		alerts Alerts
+		want   []string
+	}{
+		{"Parent route and continue", Alerts{Receiver: "admins", CommonLabels: KV{"env": "prod"}}, []string{"prod-alerts", "admins"}},
+		{"Child route", Alerts{Receiver: "admins", CommonLabels: KV{"env": "prod", "severity": "critical"}}, []string{"prod-pages", "prod-alerts", "admins"}},
+		{"Group labels", Alerts{Receiver: "admins", GroupLabels: KV{"env": "prod", "severity": "page"}}, []string{"prod-pages", "prod-alerts", "admins"}},
+		{"Receiver regex", Alerts{Receiver: "admin-team"}, []string{"admins"}},
+		{"No match", Alerts{Receiver: "developers"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := topicNames(config.Targets(&tt.alerts))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Targets() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Targets() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSNSAlertEndpointWithRoutes(t *testing.T) {
+	config, err := loadConfig("testdata/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routeConfig = config
+	defer func() { routeConfig = nil }()
+
+	svc = sns.New(mockJsonDataSession)
+	awsSession = makeMockSTSSession()
+	resetClients()
+
+	// Test that a payload matching a route is published without topic in the URL
+	req, _ := http.NewRequest("POST", "/alert", bytes.NewReader(data))
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	// Test that a payload matching no route needs the topic in the URL
+	routeConfig = &Config{}
+	req, _ = http.NewRequest("POST", "/alert", bytes.NewReader(data))
+	testHTTPResponse(t, r, req, http.StatusBadRequest)
+
+	arnPrefixCorrectTemp := "arn:aws:sns:eu-central-1:123456789012:"
+	arnPrefix = &arnPrefixCorrectTemp
+	req, _ = http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
+	testHTTPResponse(t, r, req, http.StatusOK)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// dedup holds the notifications delivered recently, deduplication is
+	// disabled if it is nil
+	dedup *dedupCache
+
+	deduplicatedNotifications = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deduplicated_notifications_total",
+			Help:      "Total number of notifications acknowledged without publishing because they were delivered within the deduplication window.",
+		},
+	)
+)
+
+// dedupCache holds the keys of notifications seen within the window. A key
+// is reserved before the notification is published and released if
+// publishing fails, so Alertmanager can retry it. If file is set, the keys
+// are persisted there and survive restarts.
+type dedupCache struct {
+	window time.Duration
+	file   string
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// newDedupCache returns a cache of the given window, loading the keys of a
+// previous run from file
+func newDedupCache(window time.Duration, file string) (*dedupCache, error) {
+	d := &dedupCache{window: window, file: file, entries: make(map[string]time.Time)}
+	if file == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read deduplication file: %v", err)
+	}
+	if err := json.Unmarshal(data, &d.entries); err != nil {
+		log.Warnf("Ignoring corrupt deduplication file %s: %v", file, err)
+		d.entries = make(map[string]time.Time)
+	}
+	d.prune(time.Now())
+	log.Infof("Loaded %d entries from deduplication file %s", len(d.entries), file)
+
+	return d, nil
+}
+
+// reserve records the key and reports whether it was not seen within the
+// window
+func (d *dedupCache) reserve(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.prune(now)
+	if _, ok := d.entries[key]; ok {
+		return false
+	}
+	d.entries[key] = now.Add(d.window)
+
+	return true
+}
+
+// release forgets the key of a notification that failed to be published
+func (d *dedupCache) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.entries, key)
+	d.save()
+}
+
+// persist writes the keys, including those of published notifications, to
+// the file
+func (d *dedupCache) persist() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.save()
+}
+
+// prune removes the keys whose window has passed
+func (d *dedupCache) prune(now time.Time) {
+	for key, expiry := range d.entries {
+		if !now.Before(expiry) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// save writes the keys to the file, replacing it atomically
+func (d *dedupCache) save() {
+	if d.file == "" {
+		return
+	}
+
+	data, err := json.Marshal(d.entries)
+	if err != nil {
+		log.Errorf("Cannot encode deduplication entries: %v", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.file), ".dedup")
+	if err != nil {
+		log.Errorf("Cannot write deduplication file: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Errorf("Cannot write deduplication file: %v", err)
+	}
+}
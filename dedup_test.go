@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNotificationKey(t *testing.T) {
+	alerts := testAlerts(t)
+	second := alerts.Alerts[0]
+	second.Fingerprint = "second"
+	alerts.Alerts = append(alerts.Alerts, second)
+	key := notificationKey(&alerts, "alerts")
+
+	reordered := alerts
+	reordered.Alerts = []Alert{alerts.Alerts[1], alerts.Alerts[0]}
+	if notificationKey(&reordered, "alerts") != key {
+		t.Error("Order of the alerts changed the key")
+	}
+
+	if notificationKey(&alerts, "other-topic") == key {
+		t.Error("Topic did not change the key")
+	}
+
+	restarted := alerts
+	restarted.Alerts = append([]Alert(nil), alerts.Alerts...)
+	restarted.Alerts[0].StartsAt = restarted.Alerts[0].StartsAt.Add(time.Minute)
+	if notificationKey(&restarted, "alerts") == key {
+		t.Error("Start of an alert did not change the key")
+	}
+
+	resolved := alerts
+	resolved.Status = AlertResolved
+	if notificationKey(&resolved, "alerts") == key {
+		t.Error("Status did not change the key")
+	}
+}
+
+func TestDedupEndpoint(t *testing.T) {
+	var err error
+	if dedup, err = newDedupCache(time.Minute, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { dedup = nil }()
+
+	arnPrefixCorrectTemp := "arn:aws:sns:eu-central-1:123456789012:"
+	arnPrefix = &arnPrefixCorrectTemp
+	post := func(want int) {
+		req, _ := http.NewRequest("POST", "/alert/dedup-topic", bytes.NewReader(data))
+		testHTTPResponse(t, r, req, want)
+	}
+
+	successful := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSNS, "dedup-topic"))
+	deduplicated := testutil.ToFloat64(deduplicatedNotifications)
+
+	// Test that a failed publish does not keep Alertmanager from retrying
+	svc = sns.New(makeMockSession(http.StatusInternalServerError, data)())
+	post(http.StatusServiceUnavailable)
+	svc = sns.New(mockJsonDataSession)
+	post(http.StatusOK)
+
+	// Test that the duplicate is acknowledged without publishing
+	post(http.StatusOK)
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSNS, "dedup-topic")) - successful; got != 1 {
+		t.Errorf("successful requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(deduplicatedNotifications) - deduplicated; got != 1 {
+		t.Errorf("deduplicated notifications = %v, want 1", got)
+	}
+}
+
+func TestDedupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dedup.json")
+
+	cache, err := newDedupCache(time.Minute, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.reserve("published")
+	cache.reserve("failed")
+	cache.release("failed")
+	cache.persist()
+
+	// Test that published keys survive a restart
+	cache, err = newDedupCache(time.Minute, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.reserve("published") {
+		t.Error("Published key was lost on restart")
+	}
+	if !cache.reserve("failed") {
+		t.Error("Released key was kept")
+	}
+
+	// Test that keys expire with the window
+	cache.entries["published"] = time.Now().Add(-time.Second)
+	if !cache.reserve("published") {
+		t.Error("Expired key was kept")
+	}
+
+	ioutil.WriteFile(file, []byte("corrupt"), 0600)
+	if _, err := newDedupCache(time.Minute, file); err != nil {
+		t.Errorf("Corrupt file was not ignored: %v", err)
+	}
+}
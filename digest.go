@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultDigestMaxSize = 100
+
+var (
+	// digests holds the notifications buffered for every destination
+	digests   = make(map[destination]*digestBuffer)
+	digestsMu sync.Mutex
+
+	digestsPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "digests_total",
+			Help:      "Total number of digests of buffered notifications published.",
+		},
+		labels,
+	)
+
+	digestedNotifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "digested_notifications_total",
+			Help:      "Total number of notifications coalesced into digests.",
+		},
+		labels,
+	)
+)
+
+// Digest configures a target buffering notifications for Window and
+// publishing them as a single digest message, rendered with Template
+type Digest struct {
+	Window   Duration `yaml:"window"`
+	MaxSize  int      `yaml:"max_size"`
+	Template string   `yaml:"template"`
+}
+
+// initDigest validates the digest options of the target and parses the
+// digest template
+func (t *Target) initDigest() error {
+	d := t.Digest
+	if d == nil {
+		return nil
+	}
+
+	if d.Window <= 0 {
+		return fmt.Errorf("digest needs a window")
+	}
+	if d.MaxSize < 0 {
+		return fmt.Errorf("digest max_size must not be negative")
+	}
+	if d.MaxSize == 0 {
+		d.MaxSize = defaultDigestMaxSize
+	}
+	if t.PerAlert {
+		return fmt.Errorf("digest is not supported with per_alert")
+	}
+	if t.Kinesis != nil || t.Firehose != nil {
+		return fmt.Errorf("digest is not supported for streams")
+	}
+
+	if d.Template != "" {
+		tmpl, err := parseTemplate(d.Template)
+		if err != nil {
+			return err
+		}
+		t.digestTmpl = tmpl
+	}
+
+	return nil
+}
+
+// digestBuffer holds the notifications buffered for a destination, published
+// with the target they were last buffered for
+type digestBuffer struct {
+	target        *Target
+	notifications []Alerts
+	timer         *time.Timer
+}
+
+// bufferDigest adds the notification to the digest of the target. The
+// digest is published when the window of its first notification passed or
+// it holds MaxSize notifications.
+func bufferDigest(t *Target, alerts Alerts) {
+	key := t.destination()
+	d := key.delivery
+
+	digestsMu.Lock()
+	defer digestsMu.Unlock()
+
+	b := digests[key]
+	if b == nil {
+		b = &digestBuffer{}
+		b.timer = time.AfterFunc(time.Duration(t.Digest.Window), func() { flushDigest(key, b) })
+		digests[key] = b
+	}
+	b.target = t
+	b.notifications = append(b.notifications, alerts)
+
+	log.Debugf("Buffered notification %d of the digest for %s %s", len(b.notifications), d.backend(), d.Topic)
+
+	if len(b.notifications) >= t.Digest.MaxSize && b.timer.Stop() {
+		go flushDigest(key, b)
+	}
+}
+
+// flushDigest publishes the digest of the buffer, unless it was flushed
+// already
+func flushDigest(key destination, b *digestBuffer) {
+	digestsMu.Lock()
+	if digests[key] != b {
+		digestsMu.Unlock()
+		return
+	}
+	delete(digests, key)
+	digestsMu.Unlock()
+
+	publishDigest(key.delivery, b.target, b.notifications)
+}
+
+// flushDigests publishes all buffered digests right away
+func flushDigests() {
+	digestsMu.Lock()
+	buffers := digests
+	digests = make(map[destination]*digestBuffer)
+	digestsMu.Unlock()
+
+	for key, b := range buffers {
+		b.timer.Stop()
+		publishDigest(key.delivery, b.target, b.notifications)
+	}
+}
+
+// publishDigest renders the notifications as a single notification with
+// the digest template, or the template of the target, and publishes it
+func publishDigest(d delivery, t *Target, notifications []Alerts) {
+	tmpl, _ := currentConfig()
+	alerts := mergeAlerts(notifications)
+
+	digestTarget := *t
+	if t.digestTmpl != nil {
+		digestTarget.tmpl = t.digestTmpl
+	}
+
+	requestData, err := json.Marshal(alerts)
+	if err != nil {
+		log.Errorf("Problem encoding digest for %s %s: %v", d.backend(), d.Topic, err)
+		return
+	}
+
+	inputs, err := digestTarget.publishInputs(tmpl, alerts, requestData)
+	if err != nil {
+		log.Errorf("Problem building digest for %s %s: %v", d.backend(), d.Topic, err)
+		return
+	}
+	if ok, _ := t.rateLimit(alerts, len(inputs)); !ok {
+		return
+	}
+
+	log.Infof("Publishing digest of %d notifications to %s %s", len(notifications), d.backend(), d.Topic)
+	for _, params := range inputs {
+		publish(d, params, t.replicas())
+	}
+
+	digestsPublished.WithLabelValues(d.backend(), d.Topic).Inc()
+	digestedNotifications.WithLabelValues(d.backend(), d.Topic).Add(float64(len(notifications)))
+}
+
+// mergeAlerts combines notifications into one holding all their alerts.
+// Alerts of several notifications are kept once, as of the latest one.
+// Labels and annotations are common if they are common to all
+// notifications, the digest is firing if any of its alerts is.
+func mergeAlerts(notifications []Alerts) Alerts {
+	first := notifications[0]
+	merged := Alerts{
+		Version:           first.Version,
+		Receiver:          first.Receiver,
+		ExternalURL:       first.ExternalURL,
+		Status:            AlertResolved,
+		GroupLabels:       first.GroupLabels,
+		CommonLabels:      first.CommonLabels,
+		CommonAnnotations: first.CommonAnnotations,
+	}
+
+	var groupKeys []string
+	index := make(map[string]int)
+	for _, n := range notifications {
+		groupKeys = append(groupKeys, n.GroupKey)
+		merged.TruncatedAlerts += n.TruncatedAlerts
+		merged.GroupLabels = commonKV(merged.GroupLabels, n.GroupLabels)
+		merged.CommonLabels = commonKV(merged.CommonLabels, n.CommonLabels)
+		merged.CommonAnnotations = commonKV(merged.CommonAnnotations, n.CommonAnnotations)
+
+		for _, a := range n.Alerts {
+			key := a.Fingerprint
+			if key == "" {
+				key = fmt.Sprint(a.Labels.SortedPairs())
+			}
+			if i, ok := index[key]; ok {
+				merged.Alerts[i] = a
+				continue
+			}
+			index[key] = len(merged.Alerts)
+			merged.Alerts = append(merged.Alerts, a)
+		}
+	}
+
+	for _, a := range merged.Alerts {
+		if a.Firing() {
+			merged.Status = AlertFiring
+		}
+	}
+
+	sort.Strings(groupKeys)
+	merged.GroupKey = "digest:" + hashID(strings.Join(groupKeys, "\n"))
+
+	return merged
+}
+
+// commonKV returns the pairs both maps hold
+func commonKV(a, b KV) KV {
+	common := KV{}
+	for k, v := range a {
+		if b[k] == v {
+			common[k] = v
+		}
+	}
+	return common
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMergeAlerts(t *testing.T) {
+	first := Alerts{
+		Version:      "4",
+		GroupKey:     "{}:{alertname=\"HighLatency\"}",
+		Status:       AlertResolved,
+		Receiver:     "admins",
+		CommonLabels: KV{"alertname": "HighLatency", "env": "prod"},
+		Alerts: []Alert{
+			{Status: AlertResolved, Fingerprint: "a", Labels: KV{"alertname": "HighLatency", "env": "prod"}},
+		},
+	}
+	second := Alerts{
+		Version:      "4",
+		GroupKey:     "{}:{alertname=\"DiskFull\"}",
+		Status:       AlertFiring,
+		Receiver:     "admins",
+		CommonLabels: KV{"alertname": "DiskFull", "env": "prod"},
+		Alerts: []Alert{
+			{Status: AlertFiring, Fingerprint: "b", Labels: KV{"alertname": "DiskFull", "env": "prod"}},
+			{Status: AlertFiring, Fingerprint: "a", Labels: KV{"alertname": "HighLatency", "env": "prod"}},
+		},
+	}
+
+	merged := mergeAlerts([]Alerts{first, second})
+	if merged.Status != AlertFiring || merged.Receiver != "admins" {
+		t.Errorf("status = %q, receiver = %q", merged.Status, merged.Receiver)
+	}
+	if len(merged.Alerts) != 2 || merged.Alerts[0].Fingerprint != "a" || merged.Alerts[0].Status != AlertFiring {
+		t.Errorf("Alerts were not merged with the latest status: %+v", merged.Alerts)
+	}
+	if len(merged.CommonLabels) != 1 || merged.CommonLabels["env"] != "prod" {
+		t.Errorf("common labels = %v, want env only", merged.CommonLabels)
+	}
+	if !strings.HasPrefix(merged.GroupKey, "digest:") || merged.GroupKey != mergeAlerts([]Alerts{second, first}).GroupKey {
+		t.Errorf("group key = %q, want it to depend on the groups only", merged.GroupKey)
+	}
+}
+
+func TestDigestValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		valid  bool
+	}{
+		{"Window", Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Digest: &Digest{Window: Duration(30 * time.Second)}}, true},
+		{"Template", Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Digest: &Digest{Window: Duration(time.Minute), Template: "testdata/default.tmpl"}}, true},
+		{"Missing window", Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Digest: &Digest{}}, false},
+		{"Missing template", Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Digest: &Digest{Window: Duration(time.Minute), Template: "testdata/missing.tmpl"}}, false},
+		{"Per alert", Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", PerAlert: true, Digest: &Digest{Window: Duration(time.Minute)}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// waitFor polls the condition for up to a second
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDigestEndpoint(t *testing.T) {
+	var messages []string
+	svc = sns.New(mockJsonDataSession)
+	svc.Handlers.Build.PushBack(func(r *request.Request) {
+		if params, ok := r.Params.(*sns.PublishInput); ok {
+			messages = append(messages, aws.StringValue(params.Message))
+		}
+	})
+	defer func() { svc = sns.New(mockJsonDataSession) }()
+
+	routes := []*Route{
+		{
+			Receiver: "admins",
+			Targets: []*Target{
+				{TopicARN: "arn:aws:sns:eu-central-1:123456789012:digest", Digest: &Digest{Window: Duration(time.Hour), MaxSize: 3}},
+			},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	routeConfig = &Config{Routes: routes}
+	defer func() { routeConfig = nil }()
+
+	post := func() {
+		req, _ := http.NewRequest("POST", "/alert", bytes.NewReader(data))
+		testHTTPResponse(t, r, req, http.StatusOK)
+	}
+
+	digested := testutil.ToFloat64(digestedNotifications.WithLabelValues(backendSNS, "digest"))
+	publishedBefore := testutil.ToFloat64(digestsPublished.WithLabelValues(backendSNS, "digest"))
+	published := func() float64 {
+		return testutil.ToFloat64(digestsPublished.WithLabelValues(backendSNS, "digest")) - publishedBefore
+	}
+
+	// Test that notifications are buffered until the digest is flushed
+	post()
+	post()
+	if len(messages) != 0 {
+		t.Fatalf("%d messages were published before the flush", len(messages))
+	}
+	flushDigests()
+	if len(messages) != 1 {
+		t.Fatalf("%d messages were published, want one digest", len(messages))
+	}
+	if got := testutil.ToFloat64(digestedNotifications.WithLabelValues(backendSNS, "digest")) - digested; got != 2 {
+		t.Errorf("digested notifications = %v, want 2", got)
+	}
+
+	// Test that a full digest is published before its window passed
+	post()
+	post()
+	post()
+	if !waitFor(func() bool { return published() == 2 }) {
+		t.Error("Full digest was not published")
+	}
+
+	// Test that the digest is published when its window passed
+	routes[0].Targets[0].Digest.Window = Duration(10 * time.Millisecond)
+	post()
+	if !waitFor(func() bool { return published() == 3 }) {
+		t.Error("Digest was not published after its window")
+	}
+}
+
+func TestDigestPerDestination(t *testing.T) {
+	var recipients []string
+	svc = sns.New(mockJsonDataSession)
+	svc.Handlers.Build.PushBack(func(r *request.Request) {
+		if params, ok := r.Params.(*sns.PublishInput); ok {
+			recipients = append(recipients, aws.StringValue(params.PhoneNumber))
+		}
+	})
+	defer func() { svc = sns.New(mockJsonDataSession) }()
+
+	digest := &Digest{Window: Duration(time.Hour), MaxSize: 10}
+	routes := []*Route{
+		{
+			Receiver: "admins",
+			Targets: []*Target{
+				{SMS: &SMS{PhoneNumbers: []string{"+4915112345678"}}, Digest: digest},
+				{SMS: &SMS{PhoneNumbers: []string{"+4915187654321"}}, Digest: digest},
+			},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := testAlerts(t)
+	for _, target := range routes[0].Targets {
+		bufferDigest(target, alerts)
+	}
+	flushDigests()
+
+	sort.Strings(recipients)
+	if want := []string{"+4915112345678", "+4915187654321"}; !reflect.DeepEqual(recipients, want) {
+		t.Errorf("digests were sent to %v, want %v", recipients, want)
+	}
+}
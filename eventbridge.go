@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	backendEventBridge = "eventbridge"
+
+	defaultEventBus        = "default"
+	defaultEventSource     = "alertmanager"
+	defaultEventDetailType = "Alertmanager Notification"
+)
+
+var eventBusNameRE = regexp.MustCompile(`^[/.\-_A-Za-z0-9]{1,256}$`)
+
+// EventBridge configures a target putting notifications as events on an
+// EventBridge event bus
+type EventBridge struct {
+	EventBus   string `yaml:"event_bus"`
+	Source     string `yaml:"source"`
+	DetailType string `yaml:"detail_type"`
+}
+
+// eventBridgeNotifier puts events on EventBridge event buses
+type eventBridgeNotifier struct{}
+
+// eventBridgeClient returns the EventBridge client for the key
+func eventBridgeClient(key clientKey) *eventbridge.EventBridge {
+	return client(eventbridge.ServiceName, key, func(config *aws.Config) interface{} {
+		return eventbridge.New(awsSession, config)
+	}).(*eventbridge.EventBridge)
+}
+
+// initEventBridge validates the event bus options of the target. The detail
+// type is rendered like a subject, so it replaces the subject template.
+func (t *Target) initEventBridge() error {
+	e := t.EventBridge
+
+	if e.EventBus == "" {
+		e.EventBus = defaultEventBus
+	}
+	if arn.IsARN(e.EventBus) {
+		parsed, err := arn.Parse(e.EventBus)
+		if err != nil || parsed.Service != "events" || !strings.HasPrefix(parsed.Resource, "event-bus/") {
+			return fmt.Errorf("invalid event_bus %q", e.EventBus)
+		}
+	} else if !eventBusNameRE.MatchString(e.EventBus) {
+		return fmt.Errorf("invalid event_bus %q", e.EventBus)
+	}
+
+	if e.Source == "" {
+		e.Source = defaultEventSource
+	}
+	if strings.HasPrefix(strings.ToLower(e.Source), "aws.") {
+		return fmt.Errorf("event source %q uses the reserved prefix aws.", e.Source)
+	}
+
+	if e.DetailType == "" {
+		e.DetailType = defaultEventDetailType
+	}
+	tmpl, err := parseInlineTemplate("detail_type", e.DetailType)
+	if err != nil {
+		return err
+	}
+	t.subjectTmpl = tmpl
+
+	if len(t.MessageAttributes) > 0 {
+		return fmt.Errorf("message_attributes are not supported for EventBridge")
+	}
+	if t.Subject != "" {
+		return fmt.Errorf("subject is not supported for EventBridge, use detail_type")
+	}
+	if t.Oversize == oversizeS3 {
+		return fmt.Errorf("oversize action s3 is not supported for EventBridge")
+	}
+
+	return nil
+}
+
+// eventBusName returns the name of the event bus, used as metric label
+func (e *EventBridge) eventBusName() string {
+	return e.EventBus[strings.LastIndex(e.EventBus, "/")+1:]
+}
+
+// eventBusRegion returns the region of an event bus given by ARN
+func (e *EventBridge) eventBusRegion() string {
+	if parsed, err := arn.Parse(e.EventBus); err == nil {
+		return parsed.Region
+	}
+	return ""
+}
+
+// eventEntry builds the event of a message. The message becomes the
+// detail, which has to be a JSON object, the subject the detail type.
+func eventEntry(d delivery, params *sns.PublishInput) (*eventbridge.PutEventsRequestEntry, error) {
+	detail := aws.StringValue(params.Message)
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(detail), &object); err != nil {
+		return nil, awserr.New("MalformedDetail", "event detail is not a JSON object, check the template", err)
+	}
+
+	return &eventbridge.PutEventsRequestEntry{
+		EventBusName: aws.String(d.Destination),
+		Source:       aws.String(d.Source),
+		DetailType:   params.Subject,
+		Detail:       aws.String(detail),
+	}, nil
+}
+
+// Publish implements Notifier
+func (n eventBridgeNotifier) Publish(d delivery, params *sns.PublishInput) error {
+	failures, err := n.PublishBatch(d, []*sns.PublishInput{params})
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return awserr.New(failures[0].code, failures[0].message, nil)
+	}
+	return nil
+}
+
+// PublishBatch implements batchNotifier
+func (eventBridgeNotifier) PublishBatch(d delivery, batch []*sns.PublishInput) ([]batchFailure, error) {
+	var failures []batchFailure
+	var indexes []int
+
+	entries := make([]*eventbridge.PutEventsRequestEntry, 0, len(batch))
+	for i, params := range batch {
+		entry, err := eventEntry(d, params)
+		if err != nil {
+			failures = append(failures, batchFailure{index: i, code: "MalformedDetail", message: err.Error(), senderFault: true})
+			continue
+		}
+		entries = append(entries, entry)
+		indexes = append(indexes, i)
+	}
+	if len(entries) == 0 {
+		return failures, nil
+	}
+
+	resp, err := eventBridgeClient(d.Client).PutEvents(&eventbridge.PutEventsInput{Entries: entries})
+	if err != nil {
+		return nil, err
+	}
+
+	// result entries are in the order of the request entries
+	for i, entry := range resp.Entries {
+		if entry.ErrorCode == nil || i >= len(indexes) {
+			continue
+		}
+		code := aws.StringValue(entry.ErrorCode)
+		failures = append(failures, batchFailure{
+			index:       indexes[i],
+			code:        code,
+			message:     aws.StringValue(entry.ErrorMessage),
+			senderFault: code != "InternalFailure" && code != "ThrottlingException",
+		})
+	}
+
+	return failures, nil
+}
+
+// StatusCode implements Notifier
+func (eventBridgeNotifier) StatusCode(err error) int {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case eventbridge.ErrCodeResourceNotFoundException, "ValidationException", "MalformedDetail", "InvalidArgument":
+			return http.StatusBadRequest
+		case "AccessDeniedException", "NotAuthorizedForSourceException":
+			return http.StatusForbidden
+		}
+	}
+
+	return snsReturnCode(err)
+}
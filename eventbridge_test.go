@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeMockEventBridgeSession returns a Session whose PutEvents requests
+// fail for the entry with the given index, recording the requests
+func makeMockEventBridgeSession(failIndex int, requests *[]eventbridge.PutEventsInput) *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var input eventbridge.PutEventsInput
+		json.Unmarshal(body, &input)
+		*requests = append(*requests, input)
+
+		result := eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}
+		for i := range input.Entries {
+			if i == failIndex {
+				result.FailedEntryCount = aws.Int64(1)
+				result.Entries = append(result.Entries, &eventbridge.PutEventsResultEntry{
+					ErrorCode:    aws.String("InvalidArgument"),
+					ErrorMessage: aws.String("invalid event"),
+				})
+				continue
+			}
+			result.Entries = append(result.Entries, &eventbridge.PutEventsResultEntry{EventId: aws.String(fmt.Sprint(i))})
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(result)
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	}))
+}
+
+func TestEventBridgeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		valid  bool
+	}{
+		{"Default bus", Target{EventBridge: &EventBridge{}}, true},
+		{"Bus ARN", Target{EventBridge: &EventBridge{EventBus: "arn:aws:events:eu-west-1:210987654321:event-bus/alerts"}}, true},
+		{"Invalid bus", Target{EventBridge: &EventBridge{EventBus: "alerts bus"}}, false},
+		{"Reserved source", Target{EventBridge: &EventBridge{Source: "aws.alertmanager"}}, false},
+		{"Subject", Target{EventBridge: &EventBridge{}, Subject: "Alert"}, false},
+		{"Message attributes", Target{EventBridge: &EventBridge{}, MessageAttributes: []*MessageAttribute{{Name: "severity", Label: "severity"}}}, false},
+		{"S3 offload", Target{EventBridge: &EventBridge{}, Oversize: oversizeS3}, false},
+		{"Bus and topic", Target{EventBridge: &EventBridge{}, TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	target := &Target{EventBridge: &EventBridge{EventBus: "arn:aws:events:eu-west-1:210987654321:event-bus/alerts"}}
+	if target.topicName() != "alerts" || target.client().Region != "eu-west-1" {
+		t.Errorf("topicName() = %q, client() = %+v", target.topicName(), target.client())
+	}
+}
+
+func TestEventBridgePublish(t *testing.T) {
+	var requests []eventbridge.PutEventsInput
+	awsSession = makeMockEventBridgeSession(-1, &requests)
+	resetClients()
+
+	target := &Target{
+		EventBridge: &EventBridge{
+			EventBus:   "alerts",
+			DetailType: "Alert {{ .Status }}",
+		},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := target.publishInputs(nil, testAlerts(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendEventBridge, "alerts"))
+	if code := publish(target.delivery(), inputs[0], target.replicas()); code != http.StatusOK {
+		t.Fatalf("publish() = %d, want %d", code, http.StatusOK)
+	}
+
+	entry := requests[0].Entries[0]
+	if aws.StringValue(entry.EventBusName) != "alerts" || aws.StringValue(entry.Source) != defaultEventSource {
+		t.Errorf("Event was not put on the bus: %v", entry)
+	}
+	if aws.StringValue(entry.DetailType) != "Alert firing" {
+		t.Errorf("detail type = %q, want %q", aws.StringValue(entry.DetailType), "Alert firing")
+	}
+	if aws.StringValue(entry.Detail) != string(data) {
+		t.Errorf("detail = %q, want the payload", aws.StringValue(entry.Detail))
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendEventBridge, "alerts")) - before; got != 1 {
+		t.Errorf("successful requests = %v, want 1", got)
+	}
+
+	// Test that the global template is not used for the detail
+	globalTmpl, err := parseTemplate("testdata/default.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, err = target.publishInputs(globalTmpl, testAlerts(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(inputs[0].Message) != string(data) {
+		t.Errorf("detail = %q with a global template, want the payload", aws.StringValue(inputs[0].Message))
+	}
+
+	// Test that a detail which is no JSON object is rejected
+	inputs[0].Message = aws.String("not JSON")
+	if code := publish(target.delivery(), inputs[0], nil); code != http.StatusBadRequest {
+		t.Errorf("publish() = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestEventBridgePublishAlerts(t *testing.T) {
+	var requests []eventbridge.PutEventsInput
+	awsSession = makeMockEventBridgeSession(1, &requests)
+	resetClients()
+
+	alerts := testAlerts(t)
+	alerts.Alerts = append(alerts.Alerts, alerts.Alerts[0], alerts.Alerts[0])
+	alerts.Alerts[1].Fingerprint = "failing"
+
+	target := &Target{EventBridge: &EventBridge{}, PerAlert: true}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, failed := publishAlerts(target, nil, alerts)
+	if len(requests) != 1 || len(requests[0].Entries) != 3 {
+		t.Fatalf("Alerts were not put in one request: %v", requests)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if len(failed) != 1 || failed[0].Fingerprint != "failing" || failed[0].Code != "InvalidArgument" {
+		t.Errorf("failed alerts = %+v", failed)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/DataReply/alertmanager-sns-forwarder/arnutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	snsFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "failovers_total",
+			Help:      "Total number of messages failed over to a replica topic, by the region that failed.",
+		},
+		[]string{"topic", "region"},
+	)
+
+	snsServedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "served_messages_total",
+			Help:      "Total number of messages published, by the region that accepted them.",
+		},
+		[]string{"topic", "region"},
+	)
+)
+
+// replica is a topic messages fail over to when publishing to the topic of
+// the target fails
+type replica struct {
+	TopicARN string    `json:"topicArn"`
+	Client   clientKey `json:"client"`
+}
+
+// initFailover validates the failover entries of the target, each being a
+// region or the ARN of a replica topic
+func (t *Target) initFailover() error {
+	if len(t.Failover) > 0 && t.SMS != nil {
+		return fmt.Errorf("failover is not supported for sms targets")
+	}
+
+	for _, entry := range t.Failover {
+		if arn.IsARN(entry) {
+			if !arnutil.ValidateARN(entry) {
+				return fmt.Errorf("invalid failover topic %q", entry)
+			}
+			continue
+		}
+		if !arnutil.ValidateRegionString(entry) {
+			return fmt.Errorf("failover entry %q is neither a topic ARN nor a region", entry)
+		}
+	}
+
+	return nil
+}
+
+// replicas returns the topics to fail over to, in order. Regions refer to
+// the topic of the same name and account in that region.
+func (t *Target) replicas() []replica {
+	replicas := make([]replica, 0, len(t.Failover))
+
+	for _, entry := range t.Failover {
+		topicARN := entry
+		if !arn.IsARN(entry) {
+			var err error
+			topicARN, err = arnutil.ReplaceRegion(t.TopicARN, entry)
+			if err != nil {
+				log.Warnf("Cannot fail over topic %s to region %s: %v", t.TopicARN, entry, err)
+				continue
+			}
+		}
+
+		key := t.client()
+		key.Region = arnutil.GetRegionFromARN(topicARN)
+		replicas = append(replicas, replica{TopicARN: topicARN, Client: key})
+	}
+
+	return replicas
+}
+
+// failover republishes a message whose publish failed with a throttling or
+// server error to the replicas in order, until one of them accepts it. If
+// all of them fail, the original message is handled as failed publish.
+func failover(d delivery, params *sns.PublishInput, cause error, code int, replicas []replica) int {
+	region, code, err := publishReplicas(d, params, cause, code, replicas)
+	if err != nil {
+		return publishFailed(d, params, replicas, err, code)
+	}
+
+	published(d, region, 1)
+	return http.StatusOK
+}
+
+// publishReplicas tries the replicas in order while the last error is
+// transient. It returns the region that accepted the message, or the last
+// error and its status code.
+func publishReplicas(d delivery, params *sns.PublishInput, cause error, code int, replicas []replica) (string, int, error) {
+	region := publishRegion(d.Client, params)
+
+	for _, r := range replicas {
+		if code < http.StatusInternalServerError {
+			break
+		}
+
+		requestsUnsuccessful.WithLabelValues(d.backend(), d.Topic).Inc()
+		snsFailovers.WithLabelValues(d.Topic, region).Inc()
+		log.Warnf("Publishing to topic %s in region %s failed, failing over to %s: %v", d.Topic, region, r.TopicARN, cause)
+
+		replicaParams := *params
+		replicaParams.TopicArn = aws.String(r.TopicARN)
+		region = publishRegion(r.Client, &replicaParams)
+
+		_, err := snsClient(r.Client).Publish(&replicaParams)
+		if err == nil {
+			return region, http.StatusOK, nil
+		}
+		cause, code = err, snsReturnCode(err)
+	}
+
+	return region, code, cause
+}
+
+// published counts delivered messages, for SNS by the region that
+// accepted them. Asynchronous notifiers count their messages when they
+// flush them.
+func published(d delivery, region string, n int) {
+	if _, ok := d.notifier().(asyncNotifier); ok {
+		return
+	}
+	requestsSuccessful.WithLabelValues(d.backend(), d.Topic).Add(float64(n))
+	if d.backend() == backendSNS {
+		snsServedMessages.WithLabelValues(d.Topic, region).Add(float64(n))
+	}
+}
+
+// publishRegion returns the region a message is published to
+func publishRegion(key clientKey, params *sns.PublishInput) string {
+	if topicARN := aws.StringValue(params.TopicArn); topicARN != "" {
+		return arnutil.GetRegionFromARN(topicARN)
+	}
+	if key.Region != "" {
+		return key.Region
+	}
+	if awsSession != nil {
+		return aws.StringValue(awsSession.Config.Region)
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeMockRegionSession returns a Session whose publishes to topics in the
+// regions starting with failing are throttled and succeed otherwise
+func makeMockRegionSession(failing string) *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if strings.Contains(r.PostForm.Get("TopicArn"), ":"+failing) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("<ErrorResponse><Error><Type>Sender</Type><Code>Throttled</Code><Message>Rate exceeded</Message></Error></ErrorResponse>"))
+		}
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+		MaxRetries:  aws.Int(0),
+	}))
+}
+
+func TestFailoverValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		failover []string
+		valid    bool
+	}{
+		{"Region", []string{"eu-west-1"}, true},
+		{"Topic ARN", []string{"arn:aws:sns:us-east-1:123456789012:alerts-replica"}, true},
+		{"Unknown region", []string{"moon-central-1"}, false},
+		{"Invalid ARN", []string{"arn:aws:sns"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Failover: tt.failover}
+			if err := target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts",
+		Failover: []string{"eu-west-1", "arn:aws:sns:us-east-1:123456789012:alerts-replica"},
+	}
+
+	replicas := target.replicas()
+	if len(replicas) != 2 {
+		t.Fatalf("got %d replicas, want 2", len(replicas))
+	}
+	if replicas[0].TopicARN != "arn:aws:sns:eu-west-1:123456789012:alerts" || replicas[0].Client.Region != "eu-west-1" {
+		t.Errorf("first replica = %+v", replicas[0])
+	}
+	if replicas[1].TopicARN != "arn:aws:sns:us-east-1:123456789012:alerts-replica" || replicas[1].Client.Region != "us-east-1" {
+		t.Errorf("second replica = %+v", replicas[1])
+	}
+}
+
+func TestRouteFailover(t *testing.T) {
+	route := &Route{
+		Receiver: "admins",
+		Failover: []string{"eu-west-1"},
+		Targets: []*Target{
+			{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"},
+			{SMS: &SMS{PhoneNumbers: []string{"+4915112345678"}}},
+			{QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts"},
+		},
+	}
+
+	// Test that only the topic target inherits the failover of the route
+	if err := route.init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	if len(route.Targets[0].Failover) != 1 {
+		t.Error("Topic target did not inherit the failover of the route")
+	}
+	for _, target := range route.Targets[1:] {
+		if len(target.Failover) != 0 {
+			t.Errorf("Target of %s inherited the failover of the route", target.backend())
+		}
+	}
+}
+
+func TestPublishFailover(t *testing.T) {
+	awsSession = makeMockRegionSession("eu-central-1")
+	svc = sns.New(awsSession)
+	resetClients()
+
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:failover",
+		Failover: []string{"eu-west-1"},
+	}
+	params := &sns.PublishInput{Message: aws.String("test"), TopicArn: aws.String(target.TopicARN)}
+
+	// Test that a throttled publish is served by the replica
+	failovers := testutil.ToFloat64(snsFailovers.WithLabelValues("failover", "eu-central-1"))
+	served := testutil.ToFloat64(snsServedMessages.WithLabelValues("failover", "eu-west-1"))
+	if code := publish(target.delivery(), params, target.replicas()); code != http.StatusOK {
+		t.Errorf("publish() = %d, want %d", code, http.StatusOK)
+	}
+	if got := testutil.ToFloat64(snsFailovers.WithLabelValues("failover", "eu-central-1")) - failovers; got != 1 {
+		t.Errorf("failovers = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(snsServedMessages.WithLabelValues("failover", "eu-west-1")) - served; got != 1 {
+		t.Errorf("messages served by eu-west-1 = %v, want 1", got)
+	}
+
+	// Test that the error is returned when all replicas fail
+	awsSession = makeMockRegionSession("eu-")
+	svc = sns.New(awsSession)
+	resetClients()
+
+	if code := publish(target.delivery(), params, target.replicas()); code != http.StatusServiceUnavailable {
+		t.Errorf("publish() = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// fifoIDRE matches the values SNS accepts as message group and
+// deduplication ID
+var fifoIDRE = regexp.MustCompile("^[A-Za-z0-9!\"#$%&'()*+,\\-./:;<=>?@\\[\\\\\\]^_`{|}~]{1,128}$")
+
+// messageGroupID returns the FIFO message group ID of the alerts. By
+// default all notifications of an alert group share a message group, so
+// SNS preserves their order.
+func (t *Target) messageGroupID(alerts *Alerts) (string, error) {
+	if t.groupIDTmpl == nil {
+		return hashID(alerts.GroupKey), nil
+	}
+
+	id, err := executeInlineTemplate(t.groupIDTmpl, alerts)
+	if err != nil {
+		return "", err
+	}
+	return fifoID(id), nil
+}
+
+// deduplicationID returns the FIFO deduplication ID of the alerts. By
+// default it is a hash of the notification key, so a notification retried
+// by Alertmanager is delivered only once while any change of the group is
+// delivered.
+func (t *Target) deduplicationID(alerts *Alerts) (string, error) {
+	if t.dedupIDTmpl == nil {
+		return hashID(notificationKey(alerts, "")), nil
+	}
+
+	id, err := executeInlineTemplate(t.dedupIDTmpl, alerts)
+	if err != nil {
+		return "", err
+	}
+	return fifoID(id), nil
+}
+
+// notificationKey identifies a notification by the topic, the group key,
+// the status and the fingerprint, status and start of every alert, so
+// retries by Alertmanager and notifications of HA peers share the key
+// while any change of the group does not. The topic is empty where the
+// destination is given otherwise.
+func notificationKey(alerts *Alerts, topic string) string {
+	keys := make([]string, 0, len(alerts.Alerts))
+	for _, a := range alerts.Alerts {
+		fingerprint := a.Fingerprint
+		if fingerprint == "" {
+			fingerprint = fmt.Sprint(a.Labels.SortedPairs())
+		}
+		keys = append(keys, fingerprint+":"+a.Status+":"+a.StartsAt.UTC().Format(time.RFC3339Nano))
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString(topic)
+	for _, key := range append([]string{alerts.GroupKey, alerts.Status}, keys...) {
+		buf.WriteByte(0)
+		buf.WriteString(key)
+	}
+
+	return buf.String()
+}
+
+// fifoID returns the value itself if SNS accepts it as ID, its hash
+// otherwise
+func fifoID(value string) string {
+	if fifoIDRE.MatchString(value) {
+		return value
+	}
+	return hashID(value)
+}
+
+func hashID(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
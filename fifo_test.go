@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestFIFOPublishInput(t *testing.T) {
+	alerts := testAlerts(t)
+
+	// Test that standard topics get no FIFO parameters
+	standard := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"}
+	params, err := standard.publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.MessageGroupId != nil || params.MessageDeduplicationId != nil {
+		t.Fatal("Standard topic got FIFO parameters")
+	}
+
+	// Test the default IDs of FIFO topics
+	fifo := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts.fifo"}
+	params, err = fifo.publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.MessageGroupId) != hashID(alerts.GroupKey) {
+		t.Errorf("MessageGroupId = %s", aws.StringValue(params.MessageGroupId))
+	}
+	dedupID := aws.StringValue(params.MessageDeduplicationId)
+	if !fifoIDRE.MatchString(dedupID) {
+		t.Errorf("MessageDeduplicationId = %s", dedupID)
+	}
+
+	// Test that a change of an alert status changes the deduplication ID
+	alerts.Alerts[0].Status = AlertResolved
+	params, _ = fifo.publishInput(nil, alerts, data)
+	if aws.StringValue(params.MessageDeduplicationId) == dedupID {
+		t.Error("Deduplication ID did not change with the alert status")
+	}
+}
+
+func TestFIFOTemplatedIDs(t *testing.T) {
+	alerts := testAlerts(t)
+
+	target := &Target{
+		TopicARN:        "arn:aws:sns:eu-central-1:123456789012:alerts.fifo",
+		MessageGroupID:  "{{ .CommonLabels.service }}",
+		DeduplicationID: "{{ .GroupKey }}",
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := target.publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.MessageGroupId) != "prometheus_bot" {
+		t.Errorf("MessageGroupId = %s", aws.StringValue(params.MessageGroupId))
+	}
+
+	// Test that values SNS does not accept are hashed
+	if aws.StringValue(params.MessageDeduplicationId) != hashID(alerts.GroupKey) {
+		t.Errorf("MessageDeduplicationId = %s", aws.StringValue(params.MessageDeduplicationId))
+	}
+	if fifoID(strings.Repeat("a", 129)) == strings.Repeat("a", 129) {
+		t.Error("Too long ID was not hashed")
+	}
+}
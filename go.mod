@@ -3,7 +3,7 @@ module github.com/DataReply/alertmanager-sns-forwarder
 go 1.14
 
 require (
-	github.com/aws/aws-sdk-go v1.30.7
+	github.com/aws/aws-sdk-go v1.44.0
 	github.com/gin-gonic/gin v1.6.2
 	github.com/linki/instrumented_http v0.3.0
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
@@ -11,4 +11,5 @@ require (
 	github.com/prometheus/client_golang v1.5.1
 	github.com/sirupsen/logrus v1.5.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.2.8
 )
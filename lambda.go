@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	backendLambda = "lambda"
+
+	// errCodeFunctionError is the code of errors raised by the invoked
+	// function itself
+	errCodeFunctionError = "FunctionError"
+
+	// maxFunctionErrorLength is the length of the function error payload
+	// kept in error messages
+	maxFunctionErrorLength = 512
+)
+
+// functionNameRE matches function names, ARNs and partial ARNs, optionally
+// with version or alias
+var functionNameRE = regexp.MustCompile(`^(arn:(aws[a-zA-Z-]*)?:lambda:)?([a-z]{2}(-gov)?-[a-z]+-\d{1}:)?(\d{12}:)?(function:)?([a-zA-Z0-9-_]+)(:(\$LATEST|[a-zA-Z0-9-_]+))?$`)
+
+// Lambda configures a target invoking a Lambda function with the
+// notification
+type Lambda struct {
+	Function       string `yaml:"function"`
+	InvocationType string `yaml:"invocation_type"`
+}
+
+// lambdaNotifier invokes Lambda functions
+type lambdaNotifier struct{}
+
+// lambdaClient returns the Lambda client for the key
+func lambdaClient(key clientKey) *lambda.Lambda {
+	return client(lambda.ServiceName, key, func(config *aws.Config) interface{} {
+		return lambda.New(awsSession, config)
+	}).(*lambda.Lambda)
+}
+
+// initLambda validates the function options of the target
+func (t *Target) initLambda() error {
+	l := t.Lambda
+
+	if !functionNameRE.MatchString(l.Function) {
+		return fmt.Errorf("invalid function %q", l.Function)
+	}
+
+	switch l.InvocationType {
+	case "":
+		l.InvocationType = lambda.InvocationTypeRequestResponse
+	case lambda.InvocationTypeRequestResponse, lambda.InvocationTypeEvent:
+	default:
+		return fmt.Errorf("unsupported invocation_type %q, must be %s or %s", l.InvocationType, lambda.InvocationTypeRequestResponse, lambda.InvocationTypeEvent)
+	}
+
+	if len(t.MessageAttributes) > 0 {
+		return fmt.Errorf("message_attributes are not supported for Lambda")
+	}
+	if t.Subject != "" {
+		return fmt.Errorf("subject is not supported for Lambda")
+	}
+	if t.Oversize == oversizeS3 {
+		return fmt.Errorf("oversize action s3 is not supported for Lambda")
+	}
+
+	return nil
+}
+
+// functionName returns the name of the function, used as metric label.
+// In ARNs the name follows "function", otherwise it comes first, followed
+// by version or alias.
+func (l *Lambda) functionName() string {
+	parts := strings.Split(l.Function, ":")
+	for i, part := range parts {
+		if part == "function" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return parts[0]
+}
+
+// functionRegion returns the region of a function given by ARN
+func (l *Lambda) functionRegion() string {
+	if parsed, err := arn.Parse(l.Function); err == nil {
+		return parsed.Region
+	}
+	return ""
+}
+
+// Publish implements Notifier. The message is the invocation payload,
+// which has to be JSON. Errors raised by synchronously invoked functions
+// are returned as errors of the publish.
+func (lambdaNotifier) Publish(d delivery, params *sns.PublishInput) error {
+	payload := []byte(aws.StringValue(params.Message))
+	if !json.Valid(payload) {
+		return awserr.New(lambda.ErrCodeInvalidRequestContentException, "invocation payload is not JSON, check the template", nil)
+	}
+
+	resp, err := lambdaClient(d.Client).Invoke(&lambda.InvokeInput{
+		FunctionName:   aws.String(d.Destination),
+		InvocationType: aws.String(d.InvocationType),
+		Payload:        payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.FunctionError != nil {
+		message := string(resp.Payload)
+		if len(message) > maxFunctionErrorLength {
+			message = message[:maxFunctionErrorLength]
+		}
+		return awserr.New(errCodeFunctionError, fmt.Sprintf("%s function error: %s", aws.StringValue(resp.FunctionError), message), nil)
+	}
+
+	log.Infof("Invoked function %s with status %d", d.Destination, aws.Int64Value(resp.StatusCode))
+	log.Debugf("Function response: %s", resp.Payload)
+
+	return nil
+}
+
+// StatusCode implements Notifier. Function errors are reported as bad
+// gateway, so Alertmanager retries the notification.
+func (lambdaNotifier) StatusCode(err error) int {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case errCodeFunctionError:
+			return http.StatusBadGateway
+		case lambda.ErrCodeResourceNotFoundException, lambda.ErrCodeInvalidRequestContentException,
+			lambda.ErrCodeInvalidParameterValueException, lambda.ErrCodeRequestTooLargeException,
+			lambda.ErrCodeUnsupportedMediaTypeException:
+			return http.StatusBadRequest
+		case "AccessDeniedException", lambda.ErrCodeKMSAccessDeniedException:
+			return http.StatusForbidden
+		}
+	}
+
+	return snsReturnCode(err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// lambdaInvocation is an invocation recorded by the mock Lambda session
+type lambdaInvocation struct {
+	path           string
+	invocationType string
+	payload        string
+}
+
+// makeMockLambdaSession returns a Session whose invocations of the failing
+// function raise an unhandled function error, recording the invocations
+func makeMockLambdaSession(failing string, invocations *[]lambdaInvocation) *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		invocationType := r.Header.Get("X-Amz-Invocation-Type")
+		*invocations = append(*invocations, lambdaInvocation{r.URL.Path, invocationType, string(body)})
+
+		if strings.Contains(r.URL.Path, "/functions/missing/") {
+			w.Header().Set("X-Amzn-Errortype", lambda.ErrCodeResourceNotFoundException)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Function not found"}`))
+			return
+		}
+
+		if invocationType == lambda.InvocationTypeEvent {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/functions/"+failing+"/") {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+			w.Write([]byte(`{"errorMessage":"remediation failed","errorType":"Error"}`))
+			return
+		}
+		w.Write([]byte(`{"remediated":true}`))
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	}))
+}
+
+func TestLambdaValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		valid  bool
+	}{
+		{"Name", Target{Lambda: &Lambda{Function: "remediate"}}, true},
+		{"Alias", Target{Lambda: &Lambda{Function: "remediate:live"}}, true},
+		{"ARN", Target{Lambda: &Lambda{Function: "arn:aws:lambda:eu-west-1:210987654321:function:remediate:3"}}, true},
+		{"Event", Target{Lambda: &Lambda{Function: "remediate", InvocationType: lambda.InvocationTypeEvent}}, true},
+		{"Dry run", Target{Lambda: &Lambda{Function: "remediate", InvocationType: lambda.InvocationTypeDryRun}}, false},
+		{"Invalid function", Target{Lambda: &Lambda{Function: "remediate alerts"}}, false},
+		{"Subject", Target{Lambda: &Lambda{Function: "remediate"}, Subject: "Alert"}, false},
+		{"Function and topic", Target{Lambda: &Lambda{Function: "remediate"}, TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	names := map[string]string{
+		"remediate":                       "remediate",
+		"remediate:live":                  "remediate",
+		"210987654321:function:remediate": "remediate",
+		"arn:aws:lambda:eu-west-1:210987654321:function:remediate:3": "remediate",
+	}
+	for function, want := range names {
+		target := &Target{Lambda: &Lambda{Function: function}}
+		if got := target.topicName(); got != want {
+			t.Errorf("topicName() of %q = %q, want %q", function, got, want)
+		}
+	}
+
+	target := &Target{Lambda: &Lambda{Function: "arn:aws:lambda:eu-west-1:210987654321:function:remediate"}}
+	if target.client().Region != "eu-west-1" {
+		t.Errorf("client() = %+v, want the region of the function", target.client())
+	}
+}
+
+func TestLambdaPublish(t *testing.T) {
+	var invocations []lambdaInvocation
+	awsSession = makeMockLambdaSession("failing", &invocations)
+	resetClients()
+
+	target := &Target{Lambda: &Lambda{Function: "remediate"}}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := target.publishInputs(nil, testAlerts(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendLambda, "remediate"))
+	if code := publish(target.delivery(), inputs[0], nil); code != http.StatusOK {
+		t.Fatalf("publish() = %d, want %d", code, http.StatusOK)
+	}
+	if len(invocations) != 1 || invocations[0].invocationType != lambda.InvocationTypeRequestResponse {
+		t.Fatalf("Function was not invoked synchronously: %+v", invocations)
+	}
+	if invocations[0].payload != string(data) {
+		t.Errorf("payload = %q, want the webhook payload", invocations[0].payload)
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendLambda, "remediate")) - before; got != 1 {
+		t.Errorf("successful requests = %v, want 1", got)
+	}
+
+	tests := []struct {
+		name   string
+		lambda Lambda
+		want   int
+	}{
+		{"Asynchronous", Lambda{Function: "failing", InvocationType: lambda.InvocationTypeEvent}, http.StatusOK},
+		{"Function error", Lambda{Function: "failing"}, http.StatusBadGateway},
+		{"Missing function", Lambda{Function: "missing"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{Lambda: &tt.lambda}
+			if err := target.init(); err != nil {
+				t.Fatal(err)
+			}
+			if code := publish(target.delivery(), inputs[0], nil); code != tt.want {
+				t.Errorf("publish() = %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	// Test that a payload which is no JSON is rejected without invoking
+	invocations = nil
+	inputs[0].Message = aws.String("not JSON")
+	if code := publish(target.delivery(), inputs[0], nil); code != http.StatusBadRequest || len(invocations) != 0 {
+		t.Errorf("publish() = %d with %d invocations, want %d without", code, len(invocations), http.StatusBadRequest)
+	}
+}
@@ -3,13 +3,13 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"path"
 	"strings"
+	"sync"
+	texttemplate "text/template"
 
 	"github.com/DataReply/alertmanager-sns-forwarder/arnutil"
 	"github.com/DataReply/alertmanager-sns-forwarder/templateutil"
@@ -21,67 +21,69 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/gin-gonic/gin"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-// Alerts is a structure for grouping Prometheus Alerts
-type Alerts struct {
-	Alerts            []Alert                `json:"alerts"`
-	CommonAnnotations map[string]interface{} `json:"commonAnnotations"`
-	CommonLabels      map[string]interface{} `json:"commonLabels"`
-	ExternalURL       string                 `json:"externalURL"`
-	GroupKey          int                    `json:"groupKey"`
-	GroupLabels       map[string]interface{} `json:"groupLabels"`
-	Receiver          string                 `json:"receiver"`
-	Status            string                 `json:"status"`
-	Version           int                    `json:"version"`
-}
-
-// Alert is a structure for a single Prometheus Alert
-type Alert struct {
-	Annotations  map[string]interface{} `json:"annotations"`
-	EndsAt       string                 `json:"endsAt"`
-	GeneratorURL string                 `json:"generatorURL"`
-	Labels       map[string]interface{} `json:"labels"`
-	StartsAt     string                 `json:"startsAt"`
-}
-
 var (
 	log = logrus.New()
 
 	listenAddr            = kingpin.Flag("addr", "Address on which to listen").Default(":9087").Envar("SNS_FORWARDER_ADDRESS").String()
 	debug                 = kingpin.Flag("debug", "Debug mode").Default("false").Envar("SNS_FORWARDER_DEBUG").Bool()
 	arnPrefix             = kingpin.Flag("arn-prefix", "Prefix to use for ARNs").Envar("SNS_FORWARDER_ARN_PREFIX").String()
-	snsSubject            = kingpin.Flag("sns-subject", "SNS subject").Envar("SNS_SUBJECT").String()
+	snsSubject            = kingpin.Flag("sns-subject", "SNS subject template").Envar("SNS_SUBJECT").String()
 	templatePath          = kingpin.Flag("template-path", "Template path").Envar("SNS_FORWARDER_TEMPLATE_PATH").String()
 	templateTimeZone      = kingpin.Flag("template-time-zone", "Template time zone").Envar("SNS_FORWARDER_TEMPLATE_TIME_ZONE").String()
 	templateTimeOutFormat = kingpin.Flag("template-time-out-format", "Template time out format").Envar("SNS_FORWARDER_TEMPLATE_TIME_OUT_FORMAT").String()
+	maxBodySize           = kingpin.Flag("max-body-size", "Maximum size of webhook payloads").Default("1MB").Envar("SNS_FORWARDER_MAX_BODY_SIZE").Bytes()
+	configFile            = kingpin.Flag("config-file", "Routing configuration file").Envar("SNS_FORWARDER_CONFIG_FILE").String()
 	templateSplitToken    = kingpin.Flag("template-split-token", "Template split token").Envar("SNS_FORWARDER_TEMPLATE_SPLIT_TOKEN").String()
+	queueDir              = kingpin.Flag("queue-dir", "Directory for the retry queue of failed publishes, disabled if empty").Envar("SNS_FORWARDER_QUEUE_DIR").String()
+	queueMaxAttempts      = kingpin.Flag("queue-max-attempts", "Publish attempts before a queued message is dead-lettered").Default("10").Envar("SNS_FORWARDER_QUEUE_MAX_ATTEMPTS").Int()
+	queueMinBackoff       = kingpin.Flag("queue-min-backoff", "Initial delay between retries of a queued message").Default("5s").Envar("SNS_FORWARDER_QUEUE_MIN_BACKOFF").Duration()
+	queueMaxBackoff       = kingpin.Flag("queue-max-backoff", "Maximum delay between retries of a queued message").Default("10m").Envar("SNS_FORWARDER_QUEUE_MAX_BACKOFF").Duration()
+	s3Endpoint            = kingpin.Flag("s3-endpoint", "Endpoint of an S3 compatible service to offload oversized messages to, AWS S3 if empty").Envar("SNS_FORWARDER_S3_ENDPOINT").String()
+	s3ForcePathStyle      = kingpin.Flag("s3-force-path-style", "Use path style S3 URLs, as needed by most S3 compatible services").Default("false").Envar("SNS_FORWARDER_S3_FORCE_PATH_STYLE").Bool()
+	streamFlushInterval   = kingpin.Flag("stream-flush-interval", "Interval at which records buffered for Kinesis and Firehose are put").Default("5s").Envar("SNS_FORWARDER_STREAM_FLUSH_INTERVAL").Duration()
+	streamMaxAttempts     = kingpin.Flag("stream-max-attempts", "Put attempts before a buffered Kinesis or Firehose record is dropped").Default("3").Envar("SNS_FORWARDER_STREAM_MAX_ATTEMPTS").Int()
+	authBearerTokenFile   = kingpin.Flag("auth-bearer-token-file", "File with the bearer tokens accepted by the webhook and admin endpoints, one per line").Envar("SNS_FORWARDER_AUTH_BEARER_TOKEN_FILE").String()
+	authBasicUsersFile    = kingpin.Flag("auth-basic-users-file", "File with the basic auth credentials accepted by the webhook and admin endpoints, one username:password per line").Envar("SNS_FORWARDER_AUTH_BASIC_USERS_FILE").String()
+	tlsCertFile           = kingpin.Flag("tls-cert-file", "Certificate file to serve TLS with, plain HTTP if empty").Envar("SNS_FORWARDER_TLS_CERT_FILE").String()
+	tlsKeyFile            = kingpin.Flag("tls-key-file", "Key file of the TLS certificate").Envar("SNS_FORWARDER_TLS_KEY_FILE").String()
+	tlsClientCAFile       = kingpin.Flag("tls-client-ca-file", "CA bundle to verify client certificates against, client certificates are not required if empty").Envar("SNS_FORWARDER_TLS_CLIENT_CA_FILE").String()
+	summaryInterval       = kingpin.Flag("rate-limit-summary-interval", "Interval at which summaries of notifications suppressed by rate limits are published").Default("1m").Envar("SNS_FORWARDER_RATE_LIMIT_SUMMARY_INTERVAL").Duration()
+	dedupWindow           = kingpin.Flag("dedup-window", "Window in which identical notifications are published only once, disabled if 0").Default("0").Envar("SNS_FORWARDER_DEDUP_WINDOW").Duration()
+	dedupFile             = kingpin.Flag("dedup-file", "File the deduplication cache is persisted to, kept in memory only if empty").Envar("SNS_FORWARDER_DEDUP_FILE").String()
+	shutdownDelay         = kingpin.Flag("shutdown-delay", "Time the listener is kept open after readiness was cleared on shutdown, counted against the grace period").Default("5s").Envar("SNS_FORWARDER_SHUTDOWN_DELAY").Duration()
+	shutdownGracePeriod   = kingpin.Flag("shutdown-grace-period", "Time in-flight requests and background work are given to finish on shutdown").Default("30s").Envar("SNS_FORWARDER_SHUTDOWN_GRACE_PERIOD").Duration()
 	svc                   *sns.SNS
 	tmpH                  *template.Template
+	subjectTmpl           *texttemplate.Template
+	routeConfig           *Config
+	retryQueue            *diskQueue
 
 	namespace = "forwarder"
 	subsystem = "sns"
-	labels    = []string{"topic"}
+	labels    = []string{"backend", "topic"}
 
-	snsRequestsSuccessful = prometheus.NewCounterVec(
+	requestsSuccessful = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "successful_requests_total",
-			Help:      "Total number of successful requests to SNS.",
+			Help:      "Total number of messages successfully delivered to the backends.",
 		},
 		labels,
 	)
 
-	snsRequestsUnsuccessful = prometheus.NewCounterVec(
+	requestsUnsuccessful = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "unsuccessful_requests_total",
-			Help:      "Total number of unsuccessful requests to SNS.",
+			Help:      "Total number of messages the backends failed to deliver.",
 		},
 		labels,
 	)
@@ -96,23 +98,38 @@ var (
 		"str_Format_Byte":        templateutil.StrFormatByte,
 		"str_Format_MeasureUnit": templateutil.StrFormatMeasureUnit,
 		"HasKey":                 templateutil.HasKey,
+		"upper":                  strings.ToUpper,
+		"lower":                  strings.ToLower,
+		"title":                  strings.Title,
 	}
 )
 
 func main() {
 	kingpin.Parse()
 
-	if templatePath != nil && *templatePath != "" {
-		tmpH = loadTemplate(templatePath)
-	} else {
-		tmpH = nil
+	registerCustomPrometheusMetrics()
+
+	var err error
+	subjectTmpl, err = parseSubject(*snsSubject)
+	if err != nil {
+		log.Fatalf("Problem parsing SNS subject template: %v", err)
 	}
 
-	if *snsSubject == "" {
-		snsSubject = nil
+	if err := reloadConfig(); err != nil {
+		log.Fatalf("Problem loading template or config file: %v", err)
 	}
+	go reloadOnSIGHUP()
 
-	registerCustomPrometheusMetrics()
+	if *authBearerTokenFile != "" {
+		if bearerTokens, err = newSecretFile(*authBearerTokenFile); err != nil {
+			log.Fatalf("Problem loading bearer tokens: %v", err)
+		}
+	}
+	if *authBasicUsersFile != "" {
+		if basicUsers, err = newSecretFile(*authBasicUsersFile); err != nil {
+			log.Fatalf("Problem loading basic auth credentials: %v", err)
+		}
+	}
 
 	config := aws.NewConfig()
 
@@ -151,7 +168,41 @@ func main() {
 		arnPrefix = &detectedArnPrefix
 	}
 
+	awsSession = session
 	svc = sns.New(session)
+	s3svc = s3.New(session, aws.NewConfig().WithEndpoint(*s3Endpoint).WithS3ForcePathStyle(*s3ForcePathStyle))
+
+	// background workers are stopped on shutdown once in-flight requests
+	// finished
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+
+	if *queueDir != "" {
+		retryQueue, err = newDiskQueue(*queueDir, *queueMaxAttempts, *queueMinBackoff, *queueMaxBackoff, delivery.publish)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			retryQueue.Run(stop)
+		}()
+	}
+
+	if *dedupWindow > 0 {
+		dedup, err = newDedupCache(*dedupWindow, *dedupFile)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		runSummaries(*summaryInterval, stop)
+	}()
 
 	if !*debug {
 		gin.SetMode(gin.ReleaseMode)
@@ -160,26 +211,68 @@ func main() {
 	}
 
 	router := gin.New()
-	router.Use(gin.LoggerWithWriter(gin.DefaultWriter, "/health", "/metrics"))
+	router.Use(gin.LoggerWithWriter(gin.DefaultWriter, "/health", "/ready", "/metrics"))
 	router.Use(gin.Recovery())
 
 	setupRouter(router)
 
-	log.Info("listening on", *listenAddr)
+	server := &http.Server{Addr: *listenAddr, Handler: router}
+	listen := server.ListenAndServe
+
+	if *tlsCertFile != "" || *tlsKeyFile != "" || *tlsClientCAFile != "" {
+		reloader, err := newTLSReloader(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile)
+		if err != nil {
+			log.Fatalf("Problem loading TLS configuration: %v", err)
+		}
+		server.TLSConfig = reloader.config()
+		listen = func() error { return server.ListenAndServeTLS("", "") }
+
+		log.Info("listening with TLS on", *listenAddr)
+	} else {
+		log.Info("listening on", *listenAddr)
+	}
 
-	router.Run(*listenAddr)
+	if err := serve(server, listen, stop, &workers); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func registerCustomPrometheusMetrics() {
-	prometheus.MustRegister(snsRequestsSuccessful)
-	prometheus.MustRegister(snsRequestsUnsuccessful)
+	prometheus.MustRegister(requestsSuccessful)
+	prometheus.MustRegister(requestsUnsuccessful)
+	prometheus.MustRegister(snsFailovers)
+	prometheus.MustRegister(snsServedMessages)
+	prometheus.MustRegister(invalidPayloads)
+	prometheus.MustRegister(deduplicatedNotifications)
+	prometheus.MustRegister(unauthorizedRequests)
+	prometheus.MustRegister(oversizedNotifications)
+	prometheus.MustRegister(s3OffloadedMessages)
+	prometheus.MustRegister(s3OffloadedBytes)
+	prometheus.MustRegister(assumeRoleFailures)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(queueOldestItemAge)
+	prometheus.MustRegister(queueDeadLettered)
+	prometheus.MustRegister(streamBufferedRecords)
+	prometheus.MustRegister(streamDroppedRecords)
+	prometheus.MustRegister(rateLimitedMessages)
+	prometheus.MustRegister(suppressionSummaries)
+	prometheus.MustRegister(digestsPublished)
+	prometheus.MustRegister(digestedNotifications)
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadSuccessTimestamp)
 }
 
 // Helper function to set up Gin routes
 func setupRouter(router *gin.Engine) {
 	router.GET("/health", healthGETHandler)
-	router.POST("/alert/:topic", alertPOSTHandler)
+	router.GET("/ready", readyGETHandler)
+	router.POST("/alert", acceptWebhooks, verifyClientCert, authenticate, alertPOSTHandler)
+	router.POST("/alert/:topic", acceptWebhooks, verifyClientCert, authenticate, alertPOSTHandler)
 	router.GET("/metrics", prometheusHandler())
+	router.POST("/-/reload", verifyClientCert, authenticate, reloadPOSTHandler)
+	router.GET("/admin/queue", verifyClientCert, authenticate, queueGETHandler)
+	router.POST("/admin/queue/:id/replay", verifyClientCert, authenticate, queueReplayPOSTHandler)
+	router.DELETE("/admin/queue/:id", verifyClientCert, authenticate, queueDELETEHandler)
 }
 
 // Gin handler for Prometheus HTTP endpoint
@@ -196,93 +289,206 @@ func healthGETHandler(c *gin.Context) {
 	})
 }
 
-func loadTemplate(tmplPath *string) *template.Template {
-	// let's read template
-	tmpH, err := template.New(path.Base(*tmplPath)).Funcs(funcMap).ParseFiles(*tmplPath)
+// parseTemplate parses a template file with the additional functions
+func parseTemplate(tmplPath string) (*template.Template, error) {
+	return template.New(path.Base(tmplPath)).Funcs(funcMap).ParseFiles(tmplPath)
+}
 
-	if err != nil {
-		log.Fatalf("Problem reading parsing template file: %v", err)
-	} else {
-		log.Printf("Load template file:%s", *tmplPath)
+// renderTemplate applies the given template to the Alerts
+func renderTemplate(tmpl *template.Template, alerts Alerts) (string, error) {
+	var bytesBuff bytes.Buffer
+
+	writer := io.Writer(&bytesBuff)
+
+	tmpl.Funcs(funcMap)
+	if err := tmpl.Execute(writer, alerts); err != nil {
+		return "", err
 	}
 
-	return tmpH
+	return bytesBuff.String(), nil
 }
 
-// AlertFormatTemplate applies the template to the Alerts
-func AlertFormatTemplate(alerts Alerts) string {
-	var bytesBuff bytes.Buffer
-	var err error
+// parseInlineTemplate parses a template given in the config file. Unlike
+// message templates these are not HTML escaped, and missing labels render
+// empty instead of "<no value>".
+func parseInlineTemplate(name string, text string) (*texttemplate.Template, error) {
+	return texttemplate.New(name).Option("missingkey=zero").Funcs(texttemplate.FuncMap(funcMap)).Parse(text)
+}
 
-	writer := io.Writer(&bytesBuff)
+// executeInlineTemplate applies an inline template to the Alerts
+func executeInlineTemplate(tmpl *texttemplate.Template, alerts *Alerts) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, alerts); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func alertPOSTHandler(c *gin.Context) {
+
+	requestData, alerts, perr := readAlerts(c.Request.Body, int64(*maxBodySize))
+	if perr != nil {
+		rejectPayload(c, perr)
+		return
+	}
 
 	if *debug {
 		log.Printf("Reloading Template\n")
 		// reload template bacause we in debug mode
-		tmpH = loadTemplate(templatePath)
+		if err := reloadConfig(); err != nil {
+			log.Errorf("Problem reloading template or config file: %v", err)
+		}
 	}
 
-	tmpH.Funcs(funcMap)
-	err = tmpH.Execute(writer, alerts)
+	if dedup != nil {
+		key := hashID(notificationKey(&alerts, c.Params.ByName("topic")))
+		if !dedup.reserve(key) {
+			log.Infof("Skipping duplicate notification for group %s", alerts.GroupKey)
+			deduplicatedNotifications.Inc()
+			c.Writer.WriteHeader(http.StatusOK)
+			return
+		}
+		defer func() {
+			if c.Writer.Status() >= http.StatusBadRequest {
+				dedup.release(key)
+				return
+			}
+			dedup.persist()
+		}()
+	}
 
-	if err != nil {
-		log.Fatalf("Problem with template execution: %v", err)
-		panic(err)
+	tmpl, config := currentConfig()
+
+	var targets []*Target
+	if config != nil {
+		targets = config.Targets(&alerts)
 	}
 
-	return bytesBuff.String()
-}
+	// the topic in the URL is the fallback route
+	if len(targets) == 0 {
+		topic := c.Params.ByName("topic")
+		if topic == "" {
+			log.Errorf("No route matches receiver %q and no topic was given", alerts.Receiver)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-func alertPOSTHandler(c *gin.Context) {
+		target := fallbackTarget(config, topic)
 
-	requestData, err := ioutil.ReadAll(c.Request.Body)
-	if err != nil {
-		log.Error(err)
-		c.Writer.WriteHeader(http.StatusBadRequest)
-		return
+		if !arnutil.ValidateARN(target.TopicARN) {
+			log.Errorf("The SNS topic ARN is not correct: %s", target.TopicARN)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		targets = []*Target{target}
 	}
-	requestString := string(requestData)
 
-	if templatePath != nil && tmpH != nil {
-		var alerts Alerts
+	// with several targets the most severe status is returned, so
+	// Alertmanager retries whenever one of them failed transiently
+	status := http.StatusOK
+	var failed []failedAlert
+	for _, target := range targets {
+		if target.Digest != nil {
+			bufferDigest(target, alerts)
+			continue
+		}
+
+		if target.PerAlert {
+			if ok, code := target.rateLimit(alerts, len(alerts.Alerts)); !ok {
+				if code > status {
+					status = code
+				}
+				continue
+			}
+			code, targetFailed := publishAlerts(target, tmpl, alerts)
+			if code > status {
+				status = code
+			}
+			failed = append(failed, targetFailed...)
+			continue
+		}
+
+		inputs, err := target.publishInputs(tmpl, alerts, requestData)
+		if err != nil {
+			log.Errorf("Problem building message for topic %s: %v", target.topicName(), err)
+			code := http.StatusInternalServerError
+			switch err {
+			case errMessageTooLarge:
+				code = http.StatusBadRequest
+			case errNoPhoneNumbers:
+				code = http.StatusBadRequest
+				d := target.delivery()
+				requestsUnsuccessful.WithLabelValues(d.backend(), d.Topic).Inc()
+			}
+			if code > status {
+				status = code
+			}
+			continue
+		}
 
-		json.Unmarshal(requestData, &alerts)
+		if ok, code := target.rateLimit(alerts, len(inputs)); !ok {
+			if code > status {
+				status = code
+			}
+			continue
+		}
 
-		requestString = AlertFormatTemplate(alerts)
+		for _, params := range inputs {
+			if code := publish(target.delivery(), params, target.replicas()); code > status {
+				status = code
+			}
+		}
 	}
 
-	topic := c.Params.ByName("topic")
-	topicArn := *arnPrefix + topic
+	if config != nil {
+		archive(config.Archive, tmpl, alerts, requestData)
+	}
 
-	if !arnutil.ValidateARN(topicArn) {
-		log.Errorf("The SNS topic ARN is not correct: %s", topicArn)
-		c.Writer.WriteHeader(http.StatusBadRequest)
+	// alerts published one by one are reported individually when failing
+	if len(failed) > 0 {
+		c.JSON(status, gin.H{"failed": failed})
 		return
 	}
 
-	log.Debugf("Using topic ARN: %s", topicArn)
+	c.Writer.WriteHeader(status)
+}
+
+// publish delivers a message with the notifier of the backend, failing
+// over to the replicas on transient errors, and returns the HTTP status
+// code to report back to Alertmanager
+func publish(d delivery, params *sns.PublishInput, replicas []replica) int {
+	log.Debugf("Publishing to %s %s", d.backend(), d.Topic)
 	log.Debugln("+------------------  A L E R T  J S O N  -------------------+")
-	log.Debugf("%s", requestString)
+	log.Debugf("%s", aws.StringValue(params.Message))
 	log.Debugln("+-----------------------------------------------------------+")
 
-	params := &sns.PublishInput{
-		Subject:  snsSubject,
-		Message:  aws.String(requestString),
-		TopicArn: aws.String(topicArn),
-	}
-
-	resp, err := svc.Publish(params)
+	err := d.publish(params)
 
 	if err != nil {
-		snsRequestsUnsuccessful.WithLabelValues(topic).Inc()
 		log.Warn(err.Error())
-		c.Writer.WriteHeader(snsReturnCode(err))
-		return
+		return failover(d, params, err, d.statusCode(err), replicas)
+	}
+
+	published(d, publishRegion(d.Client, params), 1)
+	return http.StatusOK
+}
+
+// publishFailed counts a failed publish and returns the HTTP status code to
+// report back to Alertmanager. Transient errors are retried in the
+// background when the queue is enabled, failing over to the replicas again.
+func publishFailed(d delivery, params *sns.PublishInput, replicas []replica, err error, code int) int {
+	requestsUnsuccessful.WithLabelValues(d.backend(), d.Topic).Inc()
+
+	if retryQueue != nil && code >= http.StatusInternalServerError {
+		qerr := retryQueue.Enqueue(d, params, replicas, err)
+		if qerr == nil {
+			return http.StatusAccepted
+		}
+		log.Errorf("Could not enqueue failed publish: %v", qerr)
 	}
 
-	snsRequestsSuccessful.WithLabelValues(topic).Inc()
-	log.Info(resp)
-	c.Writer.WriteHeader(http.StatusOK)
+	return code
 }
 
 // snsReturnCode will return an int HTTP Status code
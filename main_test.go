@@ -16,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/gin-gonic/gin"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
@@ -56,6 +57,11 @@ func TestMain(m *testing.M) {
 	//Set Gin to Test Mode
 	gin.SetMode(gin.TestMode)
 
+	// Apply the flag defaults
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		panic(err)
+	}
+
 	registerCustomPrometheusMetrics()
 
 	setupRouter(r)
@@ -103,23 +109,29 @@ func TestSNSAlertEndpoint(t *testing.T) {
 	// Here simulated by wrong ARN prefix
 	arnPrefixWrongTemp := "wrong"
 	arnPrefix = &arnPrefixWrongTemp
-	req, _ = http.NewRequest("POST", "/alert/test-topic", strings.NewReader("test-payload"))
+	req, _ = http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
 	testHTTPResponse(t, r, req, http.StatusBadRequest)
 
 	// Test that request using the unavailable mock Session results in ServiceUnavailable status
 	arnPrefixCorrectTemp := "arn:aws:sns:eu-central-1:123456789012:"
 	arnPrefix = &arnPrefixCorrectTemp
-	req, _ = http.NewRequest("POST", "/alert/test-topic", strings.NewReader("test-payload"))
+	req, _ = http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
 	testHTTPResponse(t, r, req, http.StatusServiceUnavailable)
 
 	// Test that request using the available mock Session results in OK status
 	svc = sns.New(mockNoReturnedDataSession)
-	req, _ = http.NewRequest("POST", "/alert/test-topic", strings.NewReader("test-payload"))
+	req, _ = http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
 	testHTTPResponse(t, r, req, http.StatusOK)
 
 	templatePathStr := "testdata/default.tmpl"
 	templatePath = &templatePathStr
-	tmpH = loadTemplate(templatePath)
+	defer func() {
+		templatePath = nil
+		tmpH = nil
+	}()
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
 	svc = sns.New(mockJsonDataSession)
 	req, _ = http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
 	testHTTPResponse(t, r, req, http.StatusOK)
@@ -130,6 +142,20 @@ func TestPrometheusEndpoint(t *testing.T) {
 	// Test that making requests to health endpoint results in OK status
 	req, _ := http.NewRequest("GET", "/metrics", nil)
 	testHTTPResponse(t, r, req, http.StatusOK)
+
+	// Test that the request metrics keep their names, with the backend label
+	requestsSuccessful.WithLabelValues(backendSNS, "metrics-topic").Inc()
+	requestsUnsuccessful.WithLabelValues(backendSQS, "metrics-queue").Inc()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for _, want := range []string{
+		`forwarder_sns_successful_requests_total{backend="sns",topic="metrics-topic"} `,
+		`forwarder_sns_unsuccessful_requests_total{backend="sqs",topic="metrics-queue"} `,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Metrics do not contain %s", want)
+		}
+	}
 }
 
 // Test_snsReturnCode helps ensure the correct HTTP return code is sent
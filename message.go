@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataReply/alertmanager-sns-forwarder/arnutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// maxMessageAttributes is the number of message attributes SNS accepts
+const maxMessageAttributes = 10
+
+// SNS message attribute data types
+const (
+	attributeString      = "String"
+	attributeNumber      = "Number"
+	attributeStringArray = "String.Array"
+)
+
+var attributeNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,256}$`)
+
+// MessageAttribute maps an alert label or annotation to an SNS message
+// attribute, so subscribers can filter on it without parsing the message
+type MessageAttribute struct {
+	Name       string `yaml:"name"`
+	Label      string `yaml:"label"`
+	Annotation string `yaml:"annotation"`
+	Type       string `yaml:"type"`
+}
+
+// validate checks the attribute against the SNS naming rules
+func (a *MessageAttribute) validate() error {
+	if !attributeNameRE.MatchString(a.Name) || strings.Contains(a.Name, "..") ||
+		strings.HasPrefix(a.Name, ".") || strings.HasSuffix(a.Name, ".") {
+		return fmt.Errorf("invalid message attribute name %q", a.Name)
+	}
+	lower := strings.ToLower(a.Name)
+	if strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+		return fmt.Errorf("message attribute name %q uses a reserved prefix", a.Name)
+	}
+
+	if (a.Label == "") == (a.Annotation == "") {
+		return fmt.Errorf("message attribute %q needs exactly one of label or annotation", a.Name)
+	}
+
+	switch a.Type {
+	case "":
+		a.Type = attributeString
+	case attributeString, attributeNumber, attributeStringArray:
+	default:
+		return fmt.Errorf("message attribute %q has unsupported type %q", a.Name, a.Type)
+	}
+
+	return nil
+}
+
+// value returns the attribute value for the alerts. String and Number
+// attributes take the common label or annotation, String.Array attributes
+// collect the distinct values of all alerts. It returns nil when the
+// alerts do not carry the label or annotation.
+func (a *MessageAttribute) value(alerts *Alerts) (*sns.MessageAttributeValue, error) {
+	lookup := func(labels, annotations KV) (string, bool) {
+		if a.Label != "" {
+			v, ok := labels[a.Label]
+			return v, ok
+		}
+		v, ok := annotations[a.Annotation]
+		return v, ok
+	}
+
+	if a.Type == attributeStringArray {
+		seen := make(map[string]bool)
+		values := []string{}
+		for _, alert := range alerts.Alerts {
+			if v, ok := lookup(alert.Labels, alert.Annotations); ok && !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil, nil
+		}
+		sort.Strings(values)
+
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		return &sns.MessageAttributeValue{
+			DataType:    aws.String(attributeStringArray),
+			StringValue: aws.String(string(encoded)),
+		}, nil
+	}
+
+	v, ok := lookup(alerts.CommonLabels, alerts.CommonAnnotations)
+	if !ok || v == "" {
+		return nil, nil
+	}
+	if a.Type == attributeNumber {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("value %q of message attribute %q is not a number", v, a.Name)
+		}
+	}
+
+	return &sns.MessageAttributeValue{
+		DataType:    aws.String(a.Type),
+		StringValue: aws.String(v),
+	}, nil
+}
+
+// messageAttributes builds the SNS message attributes for the alerts
+func messageAttributes(attributes []*MessageAttribute, alerts *Alerts) (map[string]*sns.MessageAttributeValue, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]*sns.MessageAttributeValue)
+	for _, a := range attributes {
+		v, err := a.value(alerts)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		if v != nil {
+			values[a.Name] = v
+		}
+	}
+
+	if len(values) > maxMessageAttributes {
+		return nil, fmt.Errorf("%d message attributes exceed the SNS limit of %d", len(values), maxMessageAttributes)
+	}
+
+	return values, nil
+}
+
+// messageTemplate returns the template messages of the target are rendered
+// with, nil if they are JSON: the payload or its normalized form for
+// streams. Only targets of SNS, SQS and SMS fall back to the global one.
+func (t *Target) messageTemplate(tmpl *template.Template) *template.Template {
+	switch {
+	case t.tmpl != nil:
+		return t.tmpl
+	case t.Kinesis != nil, t.Firehose != nil, t.Lambda != nil, t.EventBridge != nil:
+		return nil
+	}
+	return tmpl
+}
+
+// publishInput builds the SNS publish request of the target for the alerts.
+// The message is rendered with the template of the target, or the global
+// one, or is the raw webhook payload if there is no template at all.
+// Streams get the normalized JSON form of the alerts, Lambda functions and
+// EventBridge the payload unless the target has its own template, as the
+// global template is not meant to render JSON.
+func (t *Target) publishInput(tmpl *template.Template, alerts Alerts, requestData []byte) (*sns.PublishInput, error) {
+	message := string(requestData)
+	var err error
+
+	switch msgTmpl := t.messageTemplate(tmpl); {
+	case msgTmpl != nil:
+		message, err = renderTemplate(msgTmpl, alerts)
+	case t.Kinesis != nil, t.Firehose != nil:
+		message, err = archiveMessage(alerts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem with template execution: %v", err)
+	}
+
+	var structure *string
+	if len(t.MessageStructure) > 0 {
+		message, err = t.structuredMessage(message, alerts, requestData)
+		if err != nil {
+			return nil, err
+		}
+		structure = aws.String("json")
+	}
+
+	subject, err := t.subject(&alerts)
+	if err != nil {
+		return nil, fmt.Errorf("problem with subject template: %v", err)
+	}
+
+	attributes, err := messageAttributes(t.MessageAttributes, &alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &sns.PublishInput{
+		Subject:           subject,
+		Message:           aws.String(message),
+		MessageStructure:  structure,
+		TopicArn:          aws.String(t.TopicARN),
+		MessageAttributes: attributes,
+	}
+
+	if arnutil.IsFIFOTopic(t.TopicARN) || isFIFOQueue(t.QueueURL) {
+		groupID, err := t.messageGroupID(&alerts)
+		if err != nil {
+			return nil, fmt.Errorf("problem with message group ID template: %v", err)
+		}
+		dedupID, err := t.deduplicationID(&alerts)
+		if err != nil {
+			return nil, fmt.Errorf("problem with deduplication ID template: %v", err)
+		}
+		params.MessageGroupId = aws.String(groupID)
+		params.MessageDeduplicationId = aws.String(dedupID)
+	}
+
+	if t.Kinesis != nil {
+		key, err := t.partitionKey(&alerts)
+		if err != nil {
+			return nil, fmt.Errorf("problem with partition key template: %v", err)
+		}
+		params.MessageGroupId = aws.String(key)
+	}
+
+	return params, nil
+}
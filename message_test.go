@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func testAlerts(t *testing.T) Alerts {
+	var alerts Alerts
+	if err := json.Unmarshal(data, &alerts); err != nil {
+		t.Fatal(err)
+	}
+	return alerts
+}
+
+func TestMessageAttributeValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		attr  MessageAttribute
+		valid bool
+	}{
+		{"Label", MessageAttribute{Name: "severity", Label: "severity"}, true},
+		{"Annotation number", MessageAttribute{Name: "value", Annotation: "value", Type: "Number"}, true},
+		{"Array", MessageAttribute{Name: "instances", Label: "instance", Type: "String.Array"}, true},
+		{"No source", MessageAttribute{Name: "severity"}, false},
+		{"Both sources", MessageAttribute{Name: "severity", Label: "a", Annotation: "b"}, false},
+		{"Reserved prefix", MessageAttribute{Name: "AWS.severity", Label: "severity"}, false},
+		{"Invalid characters", MessageAttribute{Name: "sev rity", Label: "severity"}, false},
+		{"Consecutive periods", MessageAttribute{Name: "a..b", Label: "severity"}, false},
+		{"Unsupported type", MessageAttribute{Name: "severity", Label: "severity", Type: "Binary"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.attr.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMessageAttributes(t *testing.T) {
+	alerts := testAlerts(t)
+	alerts.Alerts = append(alerts.Alerts, alerts.Alerts[0])
+	alerts.Alerts[1].Labels = KV{"instance": "server02.int:9100"}
+	alerts.CommonLabels["load"] = "1.5"
+
+	attributes := []*MessageAttribute{
+		{Name: "severity", Label: "severity", Type: "String"},
+		{Name: "summary", Annotation: "summary", Type: "String"},
+		{Name: "load", Label: "load", Type: "Number"},
+		{Name: "instances", Label: "instance", Type: "String.Array"},
+		{Name: "missing", Label: "missing", Type: "String"},
+		{Name: "invalid", Label: "severity", Type: "Number"},
+	}
+
+	values, err := messageAttributes(attributes, &alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"severity":  "warning",
+		"summary":   "runit service prometheus_bot restarted, server01.int:9100",
+		"load":      "1.5",
+		"instances": `["server01.int:9100","server02.int:9100"]`,
+	}
+	if len(values) != len(want) {
+		t.Fatalf("messageAttributes() returned %d attributes, want %d", len(values), len(want))
+	}
+	for name, value := range want {
+		if aws.StringValue(values[name].StringValue) != value {
+			t.Errorf("Attribute %s = %q, want %q", name, aws.StringValue(values[name].StringValue), value)
+		}
+	}
+	if aws.StringValue(values["instances"].DataType) != "String.Array" {
+		t.Errorf("Attribute instances has type %s", aws.StringValue(values["instances"].DataType))
+	}
+}
+
+func TestMessageAttributesLimit(t *testing.T) {
+	alerts := testAlerts(t)
+
+	var attributes []*MessageAttribute
+	for i := 0; i <= maxMessageAttributes; i++ {
+		attributes = append(attributes, &MessageAttribute{Name: "a" + string(rune('a'+i)), Label: "severity", Type: "String"})
+	}
+
+	if _, err := messageAttributes(attributes, &alerts); err == nil {
+		t.Fatal("Too many message attributes were accepted")
+	}
+
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:t", MessageAttributes: attributes}
+	if err := target.init(); err == nil {
+		t.Fatal("Target with too many message attributes was accepted")
+	}
+}
+
+func TestPublishInput(t *testing.T) {
+	config, err := loadConfig("testdata/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := testAlerts(t)
+
+	params, err := config.Routes[0].Targets[0].publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.Subject) != "Production alert" || len(params.MessageAttributes) != 3 {
+		t.Errorf("publishInput() = %v", params)
+	}
+
+	// Test that the fallback target uses the defaults
+	params, err = fallbackTarget(config, "test-topic").publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.Message) != string(data) || len(params.MessageAttributes) != 1 {
+		t.Errorf("publishInput() = %v", params)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataReply/alertmanager-sns-forwarder/arnutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// Backends messages are delivered to
+const (
+	backendSNS = "sns"
+	backendSQS = "sqs"
+)
+
+// Notifier delivers messages to a backend. Messages are built as SNS
+// publish requests, other backends translate them into their own requests.
+type Notifier interface {
+	// Publish delivers a message. SNS messages carry their topic
+	// themselves, other backends deliver to the destination.
+	Publish(d delivery, params *sns.PublishInput) error
+
+	// StatusCode returns the HTTP status code reported back to
+	// Alertmanager for a publish error
+	StatusCode(err error) int
+}
+
+// notifiers holds the notifier of every backend
+var notifiers = map[string]Notifier{
+	backendSNS:         snsNotifier{},
+	backendSQS:         sqsNotifier{},
+	backendEventBridge: eventBridgeNotifier{},
+	backendKinesis:     kinesisNotifier,
+	backendFirehose:    firehoseNotifier,
+	backendLambda:      lambdaNotifier{},
+}
+
+// asyncNotifier is implemented by notifiers buffering messages and
+// delivering them in the background. Publish only accepts the message, so
+// deliveries are counted when they are flushed.
+type asyncNotifier interface {
+	Notifier
+
+	// Flush delivers all buffered messages
+	Flush()
+}
+
+// delivery describes where messages of a target are delivered to. Source
+// is the source of EventBridge events, InvocationType how Lambda functions
+// are invoked.
+type delivery struct {
+	Backend        string    `json:"backend,omitempty"`
+	Destination    string    `json:"destination,omitempty"`
+	Source         string    `json:"source,omitempty"`
+	InvocationType string    `json:"invocationType,omitempty"`
+	Topic          string    `json:"topic"`
+	Client         clientKey `json:"client"`
+}
+
+// backend returns the backend of the delivery, SNS for queue entries
+// written before there were other backends
+func (d delivery) backend() string {
+	if d.Backend == "" {
+		return backendSNS
+	}
+	return d.Backend
+}
+
+func (d delivery) notifier() Notifier {
+	return notifiers[d.backend()]
+}
+
+// publish delivers a message with the notifier of the backend
+func (d delivery) publish(params *sns.PublishInput) error {
+	return d.notifier().Publish(d, params)
+}
+
+func (d delivery) statusCode(err error) int {
+	return d.notifier().StatusCode(err)
+}
+
+// snsNotifier publishes to SNS topics and phone numbers
+type snsNotifier struct{}
+
+// Publish implements Notifier
+func (snsNotifier) Publish(d delivery, params *sns.PublishInput) error {
+	resp, err := snsClient(d.Client).Publish(params)
+	if err == nil {
+		log.Info(resp)
+	}
+	return err
+}
+
+// StatusCode implements Notifier
+func (snsNotifier) StatusCode(err error) int {
+	return snsReturnCode(err)
+}
+
+// PublishBatch implements batchNotifier
+func (snsNotifier) PublishBatch(d delivery, batch []*sns.PublishInput) ([]batchFailure, error) {
+	entries := make([]*sns.PublishBatchRequestEntry, 0, len(batch))
+	for i, params := range batch {
+		entries = append(entries, &sns.PublishBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			Message:                params.Message,
+			Subject:                params.Subject,
+			MessageStructure:       params.MessageStructure,
+			MessageAttributes:      params.MessageAttributes,
+			MessageGroupId:         params.MessageGroupId,
+			MessageDeduplicationId: params.MessageDeduplicationId,
+		})
+	}
+
+	resp, err := snsClient(d.Client).PublishBatch(&sns.PublishBatchInput{
+		TopicArn:                   batch[0].TopicArn,
+		PublishBatchRequestEntries: entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make([]batchFailure, 0, len(resp.Failed))
+	for _, entry := range resp.Failed {
+		failures = append(failures, newBatchFailure(entry.Id, entry.Code, entry.Message, entry.SenderFault, len(batch)))
+	}
+
+	return failures, nil
+}
+
+// backend returns the backend the target delivers to
+func (t *Target) backend() string {
+	switch {
+	case t.QueueURL != "":
+		return backendSQS
+	case t.EventBridge != nil:
+		return backendEventBridge
+	case t.Kinesis != nil:
+		return backendKinesis
+	case t.Firehose != nil:
+		return backendFirehose
+	case t.Lambda != nil:
+		return backendLambda
+	}
+	return backendSNS
+}
+
+// delivery returns where the messages of the target are delivered to
+func (t *Target) delivery() delivery {
+	d := delivery{
+		Backend: t.backend(),
+		Topic:   t.topicName(),
+		Client:  t.client(),
+	}
+	switch {
+	case t.QueueURL != "":
+		d.Destination = t.QueueURL
+	case t.EventBridge != nil:
+		d.Destination = t.EventBridge.EventBus
+		d.Source = t.EventBridge.Source
+	case t.Kinesis != nil:
+		d.Destination = t.Kinesis.Stream
+	case t.Firehose != nil:
+		d.Destination = t.Firehose.DeliveryStream
+	case t.Lambda != nil:
+		d.Destination = t.Lambda.Function
+		d.InvocationType = t.Lambda.InvocationType
+	}
+	return d
+}
+
+// destination identifies the target state like rate limits is kept for.
+// Unlike the delivery it tells apart topics of the same name in other
+// accounts or regions, the recipients of SMS targets and targets in
+// different places of the configuration, which may render their messages
+// differently.
+type destination struct {
+	delivery
+	TopicARN     string
+	PhoneNumbers string
+	PhoneSource  string
+	Name         string
+}
+
+// destination returns the destination of the target
+func (t *Target) destination() destination {
+	key := destination{delivery: t.delivery(), TopicARN: t.TopicARN, Name: t.name}
+	if t.SMS != nil {
+		key.PhoneNumbers = strings.Join(t.SMS.PhoneNumbers, ",")
+		key.PhoneSource = t.SMS.PhoneNumberLabel + "\x00" + t.SMS.PhoneNumberAnnotation
+	}
+	return key
+}
+
+// validateDestination checks that the target has a valid destination
+func (t *Target) validateDestination() error {
+	switch {
+	case t.QueueURL != "":
+		return validateQueueURL(t.QueueURL)
+	case t.SMS != nil, t.EventBridge != nil, t.Kinesis != nil, t.Firehose != nil, t.Lambda != nil:
+		return nil
+	case !arnutil.ValidateARN(t.TopicARN):
+		return fmt.Errorf("invalid topic_arn %q", t.TopicARN)
+	}
+	return nil
+}
+
+// initBackend checks that the target delivers to a single backend and only
+// uses options the backend supports
+func (t *Target) initBackend() error {
+	destinations := 0
+	for _, set := range []bool{t.TopicARN != "", t.QueueURL != "", t.SMS != nil, t.EventBridge != nil, t.Kinesis != nil, t.Firehose != nil, t.Lambda != nil} {
+		if set {
+			destinations++
+		}
+	}
+	if destinations > 1 {
+		return fmt.Errorf("only one of topic_arn, queue_url, sms, eventbridge, kinesis, firehose and lambda can be set")
+	}
+
+	if t.backend() != backendSNS {
+		if len(t.MessageStructure) > 0 {
+			return fmt.Errorf("message_structure is only supported for SNS topics")
+		}
+		if len(t.Failover) > 0 {
+			return fmt.Errorf("failover is only supported for SNS topics")
+		}
+	}
+
+	switch {
+	case t.EventBridge != nil:
+		return t.initEventBridge()
+	case t.Kinesis != nil, t.Firehose != nil:
+		return t.initStream()
+	case t.Lambda != nil:
+		return t.initLambda()
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// payloadPointerClass and extendedPayloadSizeAttribute make pointer
+	// messages compatible with the SNS and SQS extended client libraries
+	payloadPointerClass          = "software.amazon.payloadoffloading.PayloadS3Pointer"
+	extendedPayloadSizeAttribute = "ExtendedPayloadSize"
+
+	presignedURLAttribute = "forwarder.PresignedURL"
+	summaryAttribute      = "forwarder.Summary"
+
+	defaultPresignExpiry = 24 * time.Hour
+	maxSummaryLength     = 256
+)
+
+var (
+	s3svc *s3.S3
+
+	s3OffloadedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "offloaded_messages_total",
+			Help:      "Total number of oversized messages offloaded to S3.",
+		},
+		labels,
+	)
+
+	s3OffloadedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "offloaded_bytes_total",
+			Help:      "Total number of bytes of oversized messages offloaded to S3.",
+		},
+		labels,
+	)
+)
+
+// S3Offload configures where oversized messages are stored
+type S3Offload struct {
+	Bucket        string   `yaml:"bucket"`
+	KeyPrefix     string   `yaml:"key_prefix"`
+	PresignExpiry Duration `yaml:"presign_expiry"`
+}
+
+// s3Pointer is the payload pointer of the extended client libraries
+type s3Pointer struct {
+	BucketName string `json:"s3BucketName"`
+	Key        string `json:"s3Key"`
+}
+
+func (o *S3Offload) validate() error {
+	if o == nil || o.Bucket == "" {
+		return fmt.Errorf("oversize action %q needs an s3 bucket", oversizeS3)
+	}
+	if o.PresignExpiry < 0 || time.Duration(o.PresignExpiry) > 7*24*time.Hour {
+		return fmt.Errorf("s3 presign_expiry must be at most 7 days")
+	}
+	return nil
+}
+
+func (o *S3Offload) presignExpiry() time.Duration {
+	if o.PresignExpiry == 0 {
+		return defaultPresignExpiry
+	}
+	return time.Duration(o.PresignExpiry)
+}
+
+// offload stores the message in S3 and returns a publish request with a
+// pointer to it instead. The key is derived from the content, so retries
+// of the same notification overwrite the same object.
+func (t *Target) offload(params *sns.PublishInput, alerts *Alerts) ([]*sns.PublishInput, error) {
+	message := aws.StringValue(params.Message)
+	key := t.S3.KeyPrefix + hashID(message)
+
+	_, err := s3svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(t.S3.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader([]byte(message)),
+		ContentType: aws.String("text/plain; charset=utf-8"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot offload message to s3://%s/%s: %v", t.S3.Bucket, key, err)
+	}
+
+	req, _ := s3svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(t.S3.Bucket),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(t.S3.presignExpiry())
+	if err != nil {
+		return nil, fmt.Errorf("cannot presign s3://%s/%s: %v", t.S3.Bucket, key, err)
+	}
+
+	pointer, err := json.Marshal([]interface{}{
+		payloadPointerClass,
+		s3Pointer{BucketName: t.S3.Bucket, Key: key},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]*sns.MessageAttributeValue, len(params.MessageAttributes)+3)
+	for name, value := range params.MessageAttributes {
+		attributes[name] = value
+	}
+	attributes[extendedPayloadSizeAttribute] = &sns.MessageAttributeValue{
+		DataType:    aws.String(attributeNumber),
+		StringValue: aws.String(strconv.Itoa(len(message))),
+	}
+	attributes[presignedURLAttribute] = &sns.MessageAttributeValue{
+		DataType:    aws.String(attributeString),
+		StringValue: aws.String(url),
+	}
+	attributes[summaryAttribute] = &sns.MessageAttributeValue{
+		DataType:    aws.String(attributeString),
+		StringValue: aws.String(summary(alerts)),
+	}
+	if len(attributes) > maxMessageAttributes {
+		return nil, fmt.Errorf("%d message attributes with the s3 pointer exceed the SNS limit of %d", len(attributes), maxMessageAttributes)
+	}
+
+	s3OffloadedMessages.WithLabelValues(t.backend(), t.topicName()).Inc()
+	s3OffloadedBytes.WithLabelValues(t.backend(), t.topicName()).Add(float64(len(message)))
+
+	pointerParams := *params
+	pointerParams.Message = aws.String(string(pointer))
+	pointerParams.MessageStructure = nil
+	pointerParams.MessageAttributes = attributes
+
+	return []*sns.PublishInput{&pointerParams}, nil
+}
+
+// summary describes the alerts in a single line
+func summary(alerts *Alerts) string {
+	s := fmt.Sprintf("[%s] %d firing, %d resolved alerts for receiver %s",
+		alerts.Status, len(alerts.Firing()), len(alerts.Resolved()), alerts.Receiver)
+	if name := alerts.CommonLabels["alertname"]; name != "" {
+		s += ": " + name
+	}
+
+	runes := []rune(s)
+	if len(runes) > maxSummaryLength {
+		s = string(runes[:maxSummaryLength-3]) + "..."
+	}
+
+	return s
+}
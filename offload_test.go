@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// This helper function makes an S3 client for a local stand-in server
+// storing the uploaded objects
+func makeMockS3(objects map[string]string) *s3.S3 {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return s3.New(session.Must(session.NewSession(&aws.Config{
+		DisableSSL:       aws.Bool(true),
+		Endpoint:         aws.String(server.URL),
+		Region:           &regionString,
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	})))
+}
+
+func TestOversizeS3Offload(t *testing.T) {
+	objects := make(map[string]string)
+	s3svc = makeMockS3(objects)
+
+	alerts, requestData := makeLargeAlerts(t, 20)
+
+	target := &Target{
+		TopicARN:       "arn:aws:sns:eu-central-1:123456789012:alerts",
+		Oversize:       "s3",
+		MaxMessageSize: 2000,
+		S3:             &S3Offload{Bucket: "alerts", KeyPrefix: "payloads/"},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := target.publishInputs(nil, alerts, requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 {
+		t.Fatalf("Offloaded notification produced %d messages", len(inputs))
+	}
+
+	// Test that the pointer is compatible with the extended client libraries
+	var pointer []json.RawMessage
+	if err := json.Unmarshal([]byte(aws.StringValue(inputs[0].Message)), &pointer); err != nil || len(pointer) != 2 {
+		t.Fatalf("Message is not a payload pointer: %s", aws.StringValue(inputs[0].Message))
+	}
+	var location s3Pointer
+	json.Unmarshal(pointer[1], &location)
+	if string(pointer[0]) != `"`+payloadPointerClass+`"` || location.BucketName != "alerts" || !strings.HasPrefix(location.Key, "payloads/") {
+		t.Fatalf("Payload pointer = %s", aws.StringValue(inputs[0].Message))
+	}
+
+	// Test that the full payload was stored
+	if objects["/alerts/"+location.Key] != string(requestData) {
+		t.Fatal("Payload was not stored in S3")
+	}
+
+	attributes := inputs[0].MessageAttributes
+	if aws.StringValue(attributes[extendedPayloadSizeAttribute].StringValue) == "" ||
+		!strings.Contains(aws.StringValue(attributes[presignedURLAttribute].StringValue), location.Key) ||
+		!strings.Contains(aws.StringValue(attributes[summaryAttribute].StringValue), "20 firing") {
+		t.Errorf("Pointer message attributes = %v", attributes)
+	}
+}
+
+func TestOversizeS3Validation(t *testing.T) {
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Oversize: "s3"}
+	if err := target.init(); err == nil {
+		t.Fatal("Offloading without bucket was accepted")
+	}
+}
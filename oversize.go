@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxMessageSize is the maximum size of an SNS message including subject
+// and message attributes
+const maxMessageSize = 256 * 1024
+
+// Actions taken for notifications exceeding the maximum message size
+const (
+	oversizeSplit    = "split"
+	oversizeTruncate = "truncate"
+	oversizeS3       = "s3"
+)
+
+var (
+	errMessageTooLarge = errors.New("message exceeds the maximum size even with a single alert")
+
+	oversizedNotifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "oversized_notifications_total",
+			Help:      "Total number of notifications split, truncated or offloaded because they exceeded the maximum message size.",
+		},
+		[]string{"backend", "topic", "action"},
+	)
+)
+
+// initOversize validates the size handling options of the target
+func (t *Target) initOversize() error {
+	switch t.Oversize {
+	case "", oversizeSplit, oversizeTruncate:
+	case oversizeS3:
+		if err := t.S3.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported oversize action %q", t.Oversize)
+	}
+
+	if t.MaxMessageSize < 0 || t.MaxMessageSize > maxMessageSize {
+		return fmt.Errorf("max_message_size must be between 1 and %d", maxMessageSize)
+	}
+
+	return nil
+}
+
+func (t *Target) maxMessageSize() int {
+	if t.MaxMessageSize == 0 {
+		return maxMessageSize
+	}
+	return t.MaxMessageSize
+}
+
+// publishInputs builds the SNS publish requests of the target for the
+// alerts. Unless the target configures an oversize action this is a single
+// request, even if it exceeds the maximum message size.
+func (t *Target) publishInputs(tmpl *template.Template, alerts Alerts, requestData []byte) ([]*sns.PublishInput, error) {
+	if t.SMS != nil {
+		return t.smsInputs(tmpl, alerts, requestData)
+	}
+
+	params, err := t.publishInput(tmpl, alerts, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Oversize == "" || messageSize(params) <= t.maxMessageSize() {
+		return []*sns.PublishInput{params}, nil
+	}
+
+	var inputs []*sns.PublishInput
+	switch t.Oversize {
+	case oversizeSplit:
+		inputs, err = t.split(tmpl, alerts, params)
+	case oversizeTruncate:
+		inputs, err = t.truncate(tmpl, alerts)
+	case oversizeS3:
+		inputs, err = t.offload(params, &alerts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Notification for %s %s exceeded %d bytes, oversize action %s produced %d messages", t.backend(), t.topicName(), t.maxMessageSize(), t.Oversize, len(inputs))
+	oversizedNotifications.WithLabelValues(t.backend(), t.topicName(), t.Oversize).Inc()
+
+	return inputs, nil
+}
+
+// split halves the alerts until every part fits into a message
+func (t *Target) split(tmpl *template.Template, alerts Alerts, params *sns.PublishInput) ([]*sns.PublishInput, error) {
+	if messageSize(params) <= t.maxMessageSize() {
+		return []*sns.PublishInput{params}, nil
+	}
+	if len(alerts.Alerts) <= 1 {
+		return nil, errMessageTooLarge
+	}
+
+	half := len(alerts.Alerts) / 2
+	var inputs []*sns.PublishInput
+
+	for _, part := range [][]Alert{alerts.Alerts[:half], alerts.Alerts[half:]} {
+		partAlerts := alerts
+		partAlerts.Alerts = part
+
+		partParams, err := t.partInput(tmpl, partAlerts, 0)
+		if err != nil {
+			return nil, err
+		}
+		partInputs, err := t.split(tmpl, partAlerts, partParams)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, partInputs...)
+	}
+
+	return inputs, nil
+}
+
+// truncate keeps as many alerts as fit into a single message, counting the
+// dropped ones in TruncatedAlerts
+func (t *Target) truncate(tmpl *template.Template, alerts Alerts) ([]*sns.PublishInput, error) {
+	var best *sns.PublishInput
+
+	// binary search for the largest number of alerts that fits
+	low, high := 1, len(alerts.Alerts)-1
+	for low <= high {
+		n := (low + high) / 2
+
+		partAlerts := alerts
+		partAlerts.Alerts = alerts.Alerts[:n]
+
+		params, err := t.partInput(tmpl, partAlerts, len(alerts.Alerts)-n)
+		if err != nil {
+			return nil, err
+		}
+
+		if messageSize(params) <= t.maxMessageSize() {
+			best = params
+			low = n + 1
+		} else {
+			high = n - 1
+		}
+	}
+
+	if best == nil {
+		return nil, errMessageTooLarge
+	}
+
+	return []*sns.PublishInput{best}, nil
+}
+
+// partInput builds the publish request for a part of the alerts, with
+// dropped alerts not included in any message. Raw payloads are marshalled
+// again, templated plain text messages get a marker for the dropped alerts.
+func (t *Target) partInput(tmpl *template.Template, alerts Alerts, dropped int) (*sns.PublishInput, error) {
+	alerts.TruncatedAlerts += dropped
+
+	requestData, err := json.Marshal(alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := t.publishInput(tmpl, alerts, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON messages, i.e. untemplated, structured ones, event details and
+	// Lambda payloads, get no marker
+	if dropped > 0 && t.messageTemplate(tmpl) != nil && len(t.MessageStructure) == 0 && t.EventBridge == nil && t.Lambda == nil {
+		params.Message = aws.String(fmt.Sprintf("%s\n\n... and %d more alerts", aws.StringValue(params.Message), dropped))
+	}
+
+	return params, nil
+}
+
+// messageSize returns the size SNS accounts for a publish request
+func messageSize(params *sns.PublishInput) int {
+	size := len(aws.StringValue(params.Message)) + len(aws.StringValue(params.Subject))
+
+	for name, value := range params.MessageAttributes {
+		size += len(name) + len(aws.StringValue(value.DataType)) +
+			len(aws.StringValue(value.StringValue)) + len(value.BinaryValue)
+	}
+
+	return size
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// This helper function makes a payload with the given number of alerts
+func makeLargeAlerts(t *testing.T, n int) (Alerts, []byte) {
+	alerts := testAlerts(t)
+	alert := alerts.Alerts[0]
+	alerts.Alerts = nil
+
+	for i := 0; i < n; i++ {
+		a := alert
+		a.Fingerprint = fmt.Sprintf("%016x", i)
+		a.Annotations = KV{"description": strings.Repeat("x", 200)}
+		alerts.Alerts = append(alerts.Alerts, a)
+	}
+
+	requestData, err := json.Marshal(alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return alerts, requestData
+}
+
+func TestOversizeSplit(t *testing.T) {
+	alerts, requestData := makeLargeAlerts(t, 20)
+
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Oversize: "split", MaxMessageSize: 2000}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(oversizedNotifications.WithLabelValues(backendSNS, "alerts", "split"))
+
+	inputs, err := target.publishInputs(nil, alerts, requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) < 2 {
+		t.Fatalf("Notification was split into %d messages", len(inputs))
+	}
+
+	// Test that every message fits and all alerts are delivered
+	total := 0
+	for _, params := range inputs {
+		if messageSize(params) > 2000 {
+			t.Errorf("Message of %d bytes exceeds the maximum size", messageSize(params))
+		}
+		var part Alerts
+		if err := json.Unmarshal([]byte(aws.StringValue(params.Message)), &part); err != nil {
+			t.Fatal(err)
+		}
+		total += len(part.Alerts)
+	}
+	if total != 20 {
+		t.Errorf("Split messages contain %d alerts, want 20", total)
+	}
+
+	if testutil.ToFloat64(oversizedNotifications.WithLabelValues(backendSNS, "alerts", "split")) != before+1 {
+		t.Error("Split notification was not counted")
+	}
+}
+
+func TestOversizeTruncate(t *testing.T) {
+	alerts, requestData := makeLargeAlerts(t, 20)
+
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Oversize: "truncate", MaxMessageSize: 2000}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := target.publishInputs(nil, alerts, requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || messageSize(inputs[0]) > 2000 {
+		t.Fatal("Notification was not truncated into a single message")
+	}
+
+	var part Alerts
+	if err := json.Unmarshal([]byte(aws.StringValue(inputs[0].Message)), &part); err != nil {
+		t.Fatal(err)
+	}
+	if part.TruncatedAlerts == 0 || len(part.Alerts)+part.TruncatedAlerts != 20 {
+		t.Errorf("Truncated message has %d alerts and %d truncated", len(part.Alerts), part.TruncatedAlerts)
+	}
+
+	// Test that templated messages get a marker
+	tmpl, err := parseTemplate("testdata/default.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.MaxMessageSize = 4000
+	inputs, err = target.publishInputs(tmpl, alerts, requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(aws.StringValue(inputs[0].Message), "more alerts") {
+		t.Error("Truncated templated message has no marker")
+	}
+}
+
+func TestOversizeTruncateLambda(t *testing.T) {
+	alerts, requestData := makeLargeAlerts(t, 20)
+	tmpl, err := parseTemplate("testdata/default.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &Target{Lambda: &Lambda{Function: "remediate"}, Oversize: "truncate", MaxMessageSize: 2000}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that the payload stays valid JSON with a global template
+	inputs, err := target.publishInputs(tmpl, alerts, requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || !json.Valid([]byte(aws.StringValue(inputs[0].Message))) {
+		t.Errorf("Truncated Lambda payload is no valid JSON: %q", aws.StringValue(inputs[0].Message))
+	}
+}
+
+func TestOversizeSingleAlert(t *testing.T) {
+	alerts, requestData := makeLargeAlerts(t, 2)
+
+	for _, action := range []string{"split", "truncate"} {
+		target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", Oversize: action, MaxMessageSize: 100}
+		if _, err := target.publishInputs(nil, alerts, requestData); err != errMessageTooLarge {
+			t.Errorf("%s of alerts larger than the maximum size returned %v", action, err)
+		}
+	}
+
+	// Test that without oversize action the message is published as is
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", MaxMessageSize: 100}
+	if inputs, err := target.publishInputs(nil, alerts, requestData); err != nil || len(inputs) != 1 {
+		t.Errorf("publishInputs() = %v, %v", inputs, err)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const deadLetterDir = "dead"
+
+var (
+	errQueueEntryNotFound = errors.New("queue entry not found")
+	errQueueEntryBusy     = errors.New("queue entry is being published")
+
+	queueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "depth",
+			Help:      "Number of failed publishes waiting to be retried.",
+		},
+	)
+
+	queueOldestItemAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "oldest_item_age_seconds",
+			Help:      "Age of the oldest failed publish waiting to be retried.",
+		},
+		func() float64 {
+			if retryQueue == nil {
+				return 0
+			}
+			return retryQueue.oldestAge().Seconds()
+		},
+	)
+
+	queueDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "dead_lettered_total",
+			Help:      "Total number of failed publishes moved to the dead letter directory.",
+		},
+		labels,
+	)
+)
+
+// queueEntry is a failed SNS publish persisted in the retry queue
+type queueEntry struct {
+	ID string `json:"id"`
+	// delivery is inlined, keeping entries of older versions readable
+	delivery
+	Input       *sns.PublishInput `json:"input"`
+	Replicas    []replica         `json:"replicas,omitempty"`
+	Attempts    int               `json:"attempts"`
+	EnqueuedAt  time.Time         `json:"enqueuedAt"`
+	NextAttempt time.Time         `json:"nextAttempt"`
+	LastError   string            `json:"lastError,omitempty"`
+	Dead        bool              `json:"dead"`
+
+	// inFlight is set while the entry is published without q.mu held
+	inFlight bool
+}
+
+// diskQueue is a write-ahead queue of failed SNS publishes. Every entry is
+// stored as a JSON file in dir, so pending retries survive process restarts.
+// Entries which exhausted their attempts are moved to dir/dead.
+type diskQueue struct {
+	dir         string
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	publish     func(delivery, *sns.PublishInput) error
+
+	mu      sync.Mutex
+	entries map[string]*queueEntry
+	wake    chan struct{}
+}
+
+// newDiskQueue opens the queue in dir, creating it when needed and loading
+// the entries left over by a previous run
+func newDiskQueue(dir string, maxAttempts int, minBackoff, maxBackoff time.Duration, publish func(delivery, *sns.PublishInput) error) (*diskQueue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, deadLetterDir), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create queue directory: %v", err)
+	}
+
+	q := &diskQueue{
+		dir:         dir,
+		maxAttempts: maxAttempts,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		publish:     publish,
+		entries:     make(map[string]*queueEntry),
+		wake:        make(chan struct{}, 1),
+	}
+
+	for _, sub := range []string{dir, filepath.Join(dir, deadLetterDir)} {
+		files, err := filepath.Glob(filepath.Join(sub, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read queue entry %s: %v", file, err)
+			}
+			var e queueEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				log.Warnf("Skipping corrupt queue entry %s: %v", file, err)
+				continue
+			}
+			q.entries[e.ID] = &e
+		}
+	}
+
+	q.updateDepth()
+	log.Infof("Loaded %d entries from retry queue %s", len(q.entries), dir)
+
+	return q, nil
+}
+
+// Enqueue persists a failed publish for later retry, to the delivery or
+// the replicas to fail over to
+func (q *diskQueue) Enqueue(d delivery, input *sns.PublishInput, replicas []replica, cause error) error {
+	id, err := newQueueEntryID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	e := &queueEntry{
+		ID:          id,
+		delivery:    d,
+		Input:       input,
+		Replicas:    replicas,
+		Attempts:    1,
+		EnqueuedAt:  now,
+		NextAttempt: now.Add(q.backoff(1)),
+		LastError:   cause.Error(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.write(e); err != nil {
+		return err
+	}
+	q.entries[e.ID] = e
+	q.updateDepth()
+	q.notify()
+
+	return nil
+}
+
+// List returns a snapshot of all entries, oldest first
+func (q *diskQueue) List() []queueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]queueEntry, 0, len(q.entries))
+	for _, e := range q.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].EnqueuedAt.Before(list[j].EnqueuedAt)
+	})
+
+	return list
+}
+
+// Replay publishes an entry immediately, regardless of its backoff or
+// whether it was dead-lettered. The entry is removed on success.
+func (q *diskQueue) Replay(id string) error {
+	q.mu.Lock()
+	e, ok := q.entries[id]
+	if !ok {
+		q.mu.Unlock()
+		return errQueueEntryNotFound
+	}
+	if e.inFlight {
+		q.mu.Unlock()
+		return errQueueEntryBusy
+	}
+	e.inFlight = true
+	q.mu.Unlock()
+
+	err := q.publishEntry(e)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e.inFlight = false
+	if err != nil {
+		requestsUnsuccessful.WithLabelValues(e.backend(), e.Topic).Inc()
+		// the entry may have been dropped while it was published
+		if q.entries[e.ID] == e {
+			e.LastError = err.Error()
+			if werr := q.write(e); werr != nil {
+				log.Error(werr)
+			}
+		}
+		return err
+	}
+
+	requestsSuccessful.WithLabelValues(e.backend(), e.Topic).Inc()
+	if q.entries[e.ID] != e {
+		return nil
+	}
+	return q.remove(e)
+}
+
+// Drop deletes an entry without publishing it
+func (q *diskQueue) Drop(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[id]
+	if !ok {
+		return errQueueEntryNotFound
+	}
+
+	return q.remove(e)
+}
+
+// Run retries due entries until stop is closed
+func (q *diskQueue) Run(stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(q.retryAll())
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAll attempts every due entry and returns the time to wait until the
+// next one is due. The due entries are published without q.mu held, so
+// slow publishes do not block the webhook and the admin endpoints.
+func (q *diskQueue) retryAll() time.Duration {
+	q.mu.Lock()
+	now := time.Now()
+	wait := q.maxBackoff
+
+	var due []*queueEntry
+	for _, e := range q.entries {
+		if e.Dead || e.inFlight {
+			continue
+		}
+		if e.NextAttempt.After(now) {
+			if d := e.NextAttempt.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+		e.inFlight = true
+		due = append(due, e)
+	}
+	q.mu.Unlock()
+
+	for _, e := range due {
+		q.retry(e)
+
+		q.mu.Lock()
+		if !e.Dead && q.entries[e.ID] == e {
+			if d := time.Until(e.NextAttempt); d < wait {
+				wait = d
+			}
+		}
+		q.mu.Unlock()
+	}
+
+	return wait
+}
+
+// retry publishes a single entry marked as in flight and reschedules,
+// removes or dead-letters it depending on the outcome. Must be called
+// without q.mu held.
+func (q *diskQueue) retry(e *queueEntry) {
+	err := q.publishEntry(e)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e.inFlight = false
+	if err == nil {
+		requestsSuccessful.WithLabelValues(e.backend(), e.Topic).Inc()
+		log.Infof("Retried queue entry %s successfully after %d attempts", e.ID, e.Attempts)
+		if q.entries[e.ID] != e {
+			return
+		}
+		if err := q.remove(e); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	requestsUnsuccessful.WithLabelValues(e.backend(), e.Topic).Inc()
+	// the entry may have been dropped while it was published
+	if q.entries[e.ID] != e {
+		return
+	}
+	e.Attempts++
+	e.LastError = err.Error()
+
+	if e.Attempts >= q.maxAttempts || e.statusCode(err) < http.StatusInternalServerError {
+		log.Warnf("Dead-lettering queue entry %s after %d attempts: %v", e.ID, e.Attempts, err)
+		if err := q.deadLetter(e); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	e.NextAttempt = time.Now().Add(q.backoff(e.Attempts))
+	log.Debugf("Retry of queue entry %s failed, next attempt at %s: %v", e.ID, e.NextAttempt, err)
+	if err := q.write(e); err != nil {
+		log.Error(err)
+	}
+}
+
+// publishEntry publishes an entry, failing over to its replicas when the
+// delivery fails transiently
+func (q *diskQueue) publishEntry(e *queueEntry) error {
+	err := q.publish(e.delivery, e.Input)
+	if err == nil || len(e.Replicas) == 0 {
+		return err
+	}
+
+	_, _, err = publishReplicas(e.delivery, e.Input, err, e.statusCode(err), e.Replicas)
+	return err
+}
+
+// backoff returns the exponential delay before the given attempt
+func (q *diskQueue) backoff(attempts int) time.Duration {
+	d := q.minBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= q.maxBackoff {
+			return q.maxBackoff
+		}
+	}
+	return d
+}
+
+// oldestAge returns the age of the oldest pending entry
+func (q *diskQueue) oldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest time.Time
+	for _, e := range q.entries {
+		if !e.Dead && (oldest.IsZero() || e.EnqueuedAt.Before(oldest)) {
+			oldest = e.EnqueuedAt
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+
+	return time.Since(oldest)
+}
+
+func (q *diskQueue) deadLetter(e *queueEntry) error {
+	e.Dead = true
+	if err := q.write(e); err != nil {
+		return err
+	}
+	if err := os.Remove(q.path(e.ID, false)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	queueDeadLettered.WithLabelValues(e.backend(), e.Topic).Inc()
+	q.updateDepth()
+
+	return nil
+}
+
+func (q *diskQueue) remove(e *queueEntry) error {
+	delete(q.entries, e.ID)
+	q.updateDepth()
+
+	if err := os.Remove(q.path(e.ID, e.Dead)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// write atomically stores an entry on disk
+func (q *diskQueue) write(e *queueEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file := q.path(e.ID, e.Dead)
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+func (q *diskQueue) path(id string, dead bool) string {
+	if dead {
+		return filepath.Join(q.dir, deadLetterDir, id+".json")
+	}
+	return filepath.Join(q.dir, id+".json")
+}
+
+func (q *diskQueue) updateDepth() {
+	depth := 0
+	for _, e := range q.entries {
+		if !e.Dead {
+			depth++
+		}
+	}
+	queueDepth.Set(float64(depth))
+}
+
+func (q *diskQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// newQueueEntryID returns a unique, time ordered entry ID which is safe to
+// use as a file name
+func newQueueEntryID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix)), nil
+}
+
+// Gin handler listing the retry queue
+func queueGETHandler(c *gin.Context) {
+	if retryQueue == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retry queue is disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": retryQueue.List(),
+	})
+}
+
+// Gin handler publishing a queue entry immediately
+func queueReplayPOSTHandler(c *gin.Context) {
+	if retryQueue == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retry queue is disabled"})
+		return
+	}
+
+	id := c.Params.ByName("id")
+	if err := retryQueue.Replay(id); err != nil {
+		if err == errQueueEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err == errQueueEntryBusy {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(snsReturnCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": id})
+}
+
+// Gin handler dropping a queue entry
+func queueDELETEHandler(c *gin.Context) {
+	if retryQueue == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retry queue is disabled"})
+		return
+	}
+
+	id := c.Params.ByName("id")
+	if err := retryQueue.Drop(id); err != nil {
+		if err == errQueueEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dropped": id})
+}
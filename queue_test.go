@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// This helper function opens a queue in a fresh temporary directory
+func makeTestQueue(t *testing.T, publish func(delivery, *sns.PublishInput) error) (*diskQueue, string) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := newDiskQueue(dir, 3, time.Millisecond, 10*time.Millisecond, publish)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q, dir
+}
+
+var testDelivery = delivery{Backend: backendSNS, Topic: "test-topic"}
+
+func testPublishInput() *sns.PublishInput {
+	return &sns.PublishInput{
+		Message:  aws.String("test-payload"),
+		TopicArn: aws.String("arn:aws:sns:eu-central-1:123456789012:test-topic"),
+	}
+}
+
+func TestDiskQueueSurvivesRestart(t *testing.T) {
+	q, dir := makeTestQueue(t, func(delivery, *sns.PublishInput) error { return nil })
+	defer os.RemoveAll(dir)
+
+	if err := q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that a new queue opened on the same directory picks up the entry
+	reopened, err := newDiskQueue(dir, 3, time.Millisecond, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := reopened.List()
+	if len(entries) != 1 {
+		t.Fatalf("Reopened queue has %d entries, want 1", len(entries))
+	}
+	if *entries[0].Input.Message != "test-payload" {
+		t.Fatalf("Reopened queue entry has message %q", *entries[0].Input.Message)
+	}
+}
+
+func TestDiskQueueRetry(t *testing.T) {
+	published := 0
+	q, dir := makeTestQueue(t, func(delivery, *sns.PublishInput) error {
+		published++
+		return nil
+	})
+	defer os.RemoveAll(dir)
+
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+
+	// Test that due entries are published and removed
+	time.Sleep(5 * time.Millisecond)
+	q.retryAll()
+
+	if published != 1 {
+		t.Fatalf("Entry was published %d times, want 1", published)
+	}
+	if len(q.List()) != 0 {
+		t.Fatal("Entry was not removed after successful retry")
+	}
+}
+
+func TestDiskQueuePublishesUnlocked(t *testing.T) {
+	var q *diskQueue
+	q, dir := makeTestQueue(t, func(delivery, *sns.PublishInput) error {
+		// Test that the queue can be used while an entry is published
+		entries := q.List()
+		if len(entries) != 1 {
+			t.Errorf("%d entries while publishing, want 1", len(entries))
+		} else if err := q.Replay(entries[0].ID); err != errQueueEntryBusy {
+			t.Errorf("Replay() of entry in flight = %v, want %v", err, errQueueEntryBusy)
+		}
+		return nil
+	})
+	defer os.RemoveAll(dir)
+
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		q.retryAll()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("retryAll() deadlocked")
+	}
+	if len(q.List()) != 0 {
+		t.Fatal("Entry was not removed after successful retry")
+	}
+}
+
+func TestDiskQueueFailover(t *testing.T) {
+	awsSession = makeMockRegionSession("eu-central-1")
+	svc = sns.New(awsSession)
+	resetClients()
+	defer func() { svc = sns.New(mockJsonDataSession) }()
+
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:queue-failover",
+		Failover: []string{"eu-west-1"},
+	}
+	params := &sns.PublishInput{Message: aws.String("test"), TopicArn: aws.String(target.TopicARN)}
+
+	q, dir := makeTestQueue(t, delivery.publish)
+	defer os.RemoveAll(dir)
+	q.Enqueue(target.delivery(), params, target.replicas(), errors.New("test error"))
+
+	// Test that the retry of a reopened queue fails over to the replica
+	reopened, err := newDiskQueue(dir, 3, time.Millisecond, 10*time.Millisecond, delivery.publish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failovers := testutil.ToFloat64(snsFailovers.WithLabelValues("queue-failover", "eu-central-1"))
+	time.Sleep(5 * time.Millisecond)
+	reopened.retryAll()
+
+	if len(reopened.List()) != 0 {
+		t.Fatal("Entry was not removed after the replica accepted it")
+	}
+	if got := testutil.ToFloat64(snsFailovers.WithLabelValues("queue-failover", "eu-central-1")) - failovers; got != 1 {
+		t.Errorf("failovers = %v, want 1", got)
+	}
+}
+
+func TestDiskQueueDeadLetter(t *testing.T) {
+	q, dir := makeTestQueue(t, func(delivery, *sns.PublishInput) error {
+		return awserr.New(sns.ErrCodeInternalErrorException, "", nil)
+	})
+	defer os.RemoveAll(dir)
+
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+
+	// Test that the entry is dead-lettered after exhausting its attempts
+	for i := 0; i < 3; i++ {
+		time.Sleep(15 * time.Millisecond)
+		q.retryAll()
+	}
+
+	entries := q.List()
+	if len(entries) != 1 || !entries[0].Dead {
+		t.Fatal("Entry was not dead-lettered")
+	}
+	if q.oldestAge() != 0 {
+		t.Fatal("Dead-lettered entry counts towards the oldest item age")
+	}
+
+	// Test that permanent errors are dead-lettered immediately
+	q.publish = func(delivery, *sns.PublishInput) error {
+		return awserr.New(sns.ErrCodeInvalidParameterException, "", nil)
+	}
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+	time.Sleep(5 * time.Millisecond)
+	q.retryAll()
+
+	for _, e := range q.List() {
+		if !e.Dead {
+			t.Fatal("Entry with permanent error was not dead-lettered")
+		}
+	}
+}
+
+func TestQueueAdminEndpoints(t *testing.T) {
+	q, dir := makeTestQueue(t, func(delivery, *sns.PublishInput) error { return nil })
+	defer os.RemoveAll(dir)
+
+	// Test that the endpoints report a disabled queue
+	retryQueue = nil
+	req, _ := http.NewRequest("GET", "/admin/queue", nil)
+	testHTTPResponse(t, r, req, http.StatusNotFound)
+
+	retryQueue = q
+	defer func() { retryQueue = nil }()
+
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+	q.Enqueue(testDelivery, testPublishInput(), nil, errors.New("test error"))
+	entries := q.List()
+
+	req, _ = http.NewRequest("GET", "/admin/queue", nil)
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	req, _ = http.NewRequest("POST", "/admin/queue/"+entries[0].ID+"/replay", nil)
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	req, _ = http.NewRequest("DELETE", "/admin/queue/"+entries[1].ID, nil)
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	req, _ = http.NewRequest("DELETE", "/admin/queue/"+entries[1].ID, nil)
+	testHTTPResponse(t, r, req, http.StatusNotFound)
+
+	if len(q.List()) != 0 {
+		t.Fatal("Queue is not empty after replay and drop")
+	}
+}
+
+func TestSNSAlertEndpointQueuesTransientErrors(t *testing.T) {
+	q, dir := makeTestQueue(t, delivery.publish)
+	defer os.RemoveAll(dir)
+
+	retryQueue = q
+	defer func() { retryQueue = nil }()
+
+	arnPrefixCorrectTemp := "arn:aws:sns:eu-central-1:123456789012:"
+	arnPrefix = &arnPrefixCorrectTemp
+	templatePath = nil
+	tmpH = nil
+	svc = sns.New(mockUnavailableSession)
+
+	// Test that a failed publish is accepted and queued
+	req, _ := http.NewRequest("POST", "/alert/test-topic", bytes.NewReader(data))
+	testHTTPResponse(t, r, req, http.StatusAccepted)
+
+	if len(q.List()) != 1 {
+		t.Fatal("Failed publish was not queued")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Actions taken for notifications exceeding a rate limit
+const (
+	rateLimitSuppress = "suppress"
+	rateLimitReject   = "reject"
+)
+
+// Reasons a notification is rate limited for, used as metric label
+const (
+	reasonRate     = "rate"
+	reasonDailyCap = "daily_cap"
+)
+
+const (
+	defaultRateLimitInterval = Duration(time.Minute)
+
+	summarySubject = "Notifications suppressed"
+)
+
+var (
+	// limiters holds the rate limit state of every destination, kept
+	// across configuration reloads
+	limiters   = make(map[destination]*limiter)
+	limitersMu sync.Mutex
+
+	rateLimitedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_messages_total",
+			Help:      "Total number of messages suppressed or rejected by rate limits or daily caps.",
+		},
+		[]string{"backend", "topic", "reason", "action"},
+	)
+
+	suppressionSummaries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "suppression_summaries_total",
+			Help:      "Total number of summary messages published for suppressed notifications.",
+		},
+		labels,
+	)
+)
+
+// RateLimit limits the messages delivered to a target with a token bucket
+// refilled with Rate tokens per Interval and a cap of messages per UTC day
+type RateLimit struct {
+	Rate     float64  `yaml:"rate"`
+	Interval Duration `yaml:"interval"`
+	Burst    int      `yaml:"burst"`
+	DailyCap int      `yaml:"daily_cap"`
+	Action   string   `yaml:"action"`
+}
+
+// validate checks the rate limit options and sets their defaults
+func (l *RateLimit) validate() error {
+	if l.Rate < 0 || l.Burst < 0 || l.DailyCap < 0 || l.Interval < 0 {
+		return fmt.Errorf("rate_limit options must not be negative")
+	}
+	if l.Rate == 0 && l.DailyCap == 0 {
+		return fmt.Errorf("rate_limit needs a rate or a daily_cap")
+	}
+
+	if l.Interval == 0 {
+		l.Interval = defaultRateLimitInterval
+	}
+	if l.Burst == 0 {
+		l.Burst = int(l.Rate)
+		if l.Burst < 1 {
+			l.Burst = 1
+		}
+	}
+
+	switch l.Action {
+	case "":
+		l.Action = rateLimitSuppress
+	case rateLimitSuppress, rateLimitReject:
+	default:
+		return fmt.Errorf("unsupported rate_limit action %q", l.Action)
+	}
+
+	return nil
+}
+
+// initRateLimit validates the rate limit of the target
+func (t *Target) initRateLimit() error {
+	if t.RateLimit == nil {
+		return nil
+	}
+	if t.Kinesis != nil || t.Firehose != nil {
+		return fmt.Errorf("rate_limit is not supported for streams")
+	}
+	return t.RateLimit.validate()
+}
+
+// limiter is the rate limit state of a delivery. Suppressed notifications
+// are counted until a summary is published, built from the target and
+// alerts of the last one.
+type limiter struct {
+	tokens float64
+	filled time.Time
+	day    string
+	sent   int
+
+	suppressed int
+	since      time.Time
+	target     *Target
+	alerts     Alerts
+}
+
+// take reports whether n messages may be delivered now, and if not,
+// whether the rate or the daily cap was exceeded. A notification passes
+// if a token is left, the bucket may go into debt for the messages beyond
+// the first, the daily cap is never exceeded.
+func (l *limiter) take(limit *RateLimit, n int, now time.Time) (bool, string) {
+	if day := now.UTC().Format("2006-01-02"); day != l.day {
+		l.day, l.sent = day, 0
+	}
+
+	if l.filled.IsZero() {
+		l.tokens = float64(limit.Burst)
+	} else if limit.Rate > 0 {
+		l.tokens += now.Sub(l.filled).Seconds() * limit.Rate / time.Duration(limit.Interval).Seconds()
+		if l.tokens > float64(limit.Burst) {
+			l.tokens = float64(limit.Burst)
+		}
+	}
+	l.filled = now
+
+	if limit.DailyCap > 0 && l.sent+n > limit.DailyCap {
+		return false, reasonDailyCap
+	}
+	if limit.Rate > 0 {
+		if l.tokens < 1 {
+			return false, reasonRate
+		}
+		l.tokens -= float64(n)
+	}
+	l.sent += n
+
+	return true, ""
+}
+
+// rateLimit checks the rate limit of the target before n messages are
+// delivered for the alerts. It returns false together with the HTTP status
+// code to report back to Alertmanager if the notification is suppressed or
+// rejected.
+func (t *Target) rateLimit(alerts Alerts, n int) (bool, int) {
+	if t.RateLimit == nil || n == 0 {
+		return true, http.StatusOK
+	}
+
+	key := t.destination()
+	d := key.delivery
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l := limiters[key]
+	if l == nil {
+		l = &limiter{}
+		limiters[key] = l
+	}
+
+	ok, reason := l.take(t.RateLimit, n, time.Now())
+	if ok {
+		return true, http.StatusOK
+	}
+
+	if t.RateLimit.Action == rateLimitReject {
+		log.Warnf("Rejecting notification for %s %s exceeding its %s", d.backend(), d.Topic, reason)
+		rateLimitedMessages.WithLabelValues(d.backend(), d.Topic, reason, "rejected").Add(float64(n))
+		return false, http.StatusTooManyRequests
+	}
+
+	log.Warnf("Suppressing notification for %s %s exceeding its %s", d.backend(), d.Topic, reason)
+	rateLimitedMessages.WithLabelValues(d.backend(), d.Topic, reason, "suppressed").Add(float64(n))
+	if l.suppressed == 0 {
+		l.since = time.Now()
+	}
+	l.suppressed++
+	l.target, l.alerts = t, alerts
+
+	return false, http.StatusOK
+}
+
+// summaryMessage describes the suppressed notifications. Backends
+// expecting JSON get a JSON object.
+func summaryMessage(d delivery, suppressed int, since time.Time) string {
+	switch d.backend() {
+	case backendEventBridge, backendLambda:
+		message, _ := json.Marshal(map[string]interface{}{
+			"status":     "suppressed",
+			"topic":      d.Topic,
+			"suppressed": suppressed,
+			"since":      since.UTC(),
+		})
+		return string(message)
+	}
+	return fmt.Sprintf("%d notifications for %s suppressed by rate limiting since %s", suppressed, d.Topic, since.UTC().Format(time.RFC3339))
+}
+
+// summaryInputs builds the summary messages of the target from the
+// requests of the last suppressed alerts. Text messages go to every phone
+// number, other targets get a single message.
+func (t *Target) summaryInputs(tmpl *template.Template, alerts Alerts, message string) ([]*sns.PublishInput, error) {
+	inputs, err := t.publishInputs(tmpl, alerts, []byte(message))
+	if err != nil {
+		return nil, err
+	}
+	if t.SMS == nil && len(inputs) > 1 {
+		inputs = inputs[:1]
+	}
+
+	for _, params := range inputs {
+		params.Message = aws.String(message)
+		params.MessageStructure = nil
+		if params.Subject != nil {
+			params.Subject = aws.String(summarySubject)
+		}
+		if t.SMS != nil {
+			params.Message = aws.String(smsText(message, t.SMS.MaxLength))
+		}
+		if params.MessageDeduplicationId != nil {
+			params.MessageDeduplicationId = aws.String(hashID(message))
+		}
+	}
+
+	return inputs, nil
+}
+
+// publishSummaries publishes a summary of the suppressed notifications of
+// every destination whose rate limit allows a message again
+func publishSummaries() {
+	tmpl, _ := currentConfig()
+
+	type summary struct {
+		d      delivery
+		inputs []*sns.PublishInput
+	}
+	var summaries []summary
+
+	limitersMu.Lock()
+	for key, l := range limiters {
+		d := key.delivery
+		if l.suppressed == 0 {
+			continue
+		}
+
+		message := summaryMessage(d, l.suppressed, l.since)
+		inputs, err := l.target.summaryInputs(tmpl, l.alerts, message)
+		if err != nil {
+			log.Errorf("Problem building summary for %s %s: %v", d.backend(), d.Topic, err)
+			continue
+		}
+		if ok, _ := l.take(l.target.RateLimit, len(inputs), time.Now()); !ok {
+			continue
+		}
+
+		summaries = append(summaries, summary{d, inputs})
+		l.suppressed = 0
+	}
+	limitersMu.Unlock()
+
+	for _, s := range summaries {
+		log.Infof("Publishing summary of suppressed notifications for %s %s", s.d.backend(), s.d.Topic)
+		for _, params := range s.inputs {
+			publish(s.d, params, nil)
+		}
+		suppressionSummaries.WithLabelValues(s.d.backend(), s.d.Topic).Inc()
+	}
+}
+
+// runSummaries publishes summaries of suppressed notifications periodically
+func runSummaries(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			publishSummaries()
+		case <-stop:
+			return
+		}
+	}
+}
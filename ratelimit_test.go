@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		valid bool
+	}{
+		{"Rate", RateLimit{Rate: 10}, true},
+		{"Daily cap", RateLimit{DailyCap: 100, Action: rateLimitReject}, true},
+		{"Neither", RateLimit{Burst: 5}, false},
+		{"Negative rate", RateLimit{Rate: -1}, false},
+		{"Unknown action", RateLimit{Rate: 1, Action: "drop"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limit.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	limit := RateLimit{Rate: 0.5}
+	limit.validate()
+	if limit.Burst != 1 || limit.Interval != defaultRateLimitInterval || limit.Action != rateLimitSuppress {
+		t.Errorf("Defaults were not set: %+v", limit)
+	}
+
+	target := &Target{Kinesis: &Kinesis{Stream: "alerts"}, RateLimit: &RateLimit{Rate: 1}}
+	if err := target.init(); err == nil {
+		t.Error("Rate limited stream was accepted")
+	}
+}
+
+func TestLimiterTake(t *testing.T) {
+	limit := &RateLimit{Rate: 2, Interval: Duration(time.Minute), Burst: 2, DailyCap: 6}
+	l := &limiter{}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		after  time.Duration
+		n      int
+		ok     bool
+		reason string
+	}{
+		{0, 1, true, ""},
+		{0, 1, true, ""},
+		{0, 1, false, reasonRate},
+		{30 * time.Second, 1, true, ""},
+		// a notification passes with a single token, leaving the bucket in debt
+		{30 * time.Second, 2, true, ""},
+		{30 * time.Second, 1, false, reasonRate},
+		{time.Hour, 2, false, reasonDailyCap},
+		{0, 1, true, ""},
+		{12 * time.Hour, 1, true, ""},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		if ok, reason := l.take(limit, step.n, now); ok != step.ok || reason != step.reason {
+			t.Errorf("step %d: take() = %v, %q, want %v, %q", i, ok, reason, step.ok, step.reason)
+		}
+	}
+}
+
+func TestRateLimitEndpoint(t *testing.T) {
+	var sizes []int
+	svc = makeMockBatchSNS("", &sizes)
+	defer func() { svc = sns.New(mockJsonDataSession) }()
+
+	routes := []*Route{
+		{
+			Receiver: "admins",
+			Targets: []*Target{
+				{TopicARN: "arn:aws:sns:eu-central-1:123456789012:limited", Subject: "Alert", RateLimit: &RateLimit{Rate: 1}},
+				{TopicARN: "arn:aws:sns:eu-central-1:123456789012:rejecting", RateLimit: &RateLimit{DailyCap: 1, Action: rateLimitReject}},
+			},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	routeConfig = &Config{Routes: routes}
+	defer func() {
+		routeConfig = nil
+		limitersMu.Lock()
+		for _, target := range routes[0].Targets {
+			delete(limiters, target.destination())
+		}
+		limitersMu.Unlock()
+	}()
+
+	successful := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSNS, "limited"))
+	suppressed := testutil.ToFloat64(rateLimitedMessages.WithLabelValues(backendSNS, "limited", reasonRate, "suppressed"))
+	rejected := testutil.ToFloat64(rateLimitedMessages.WithLabelValues(backendSNS, "rejecting", reasonDailyCap, "rejected"))
+	summaries := testutil.ToFloat64(suppressionSummaries.WithLabelValues(backendSNS, "limited"))
+
+	post := func(want int) {
+		req, _ := http.NewRequest("POST", "/alert", bytes.NewReader(data))
+		testHTTPResponse(t, r, req, want)
+	}
+
+	post(http.StatusOK)
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSNS, "limited")) - successful; got != 1 {
+		t.Fatalf("successful requests = %v, want 1", got)
+	}
+
+	// Test that the second notification is suppressed for one topic and
+	// rejected for the other
+	post(http.StatusTooManyRequests)
+	post(http.StatusTooManyRequests)
+	if got := testutil.ToFloat64(rateLimitedMessages.WithLabelValues(backendSNS, "limited", reasonRate, "suppressed")) - suppressed; got != 2 {
+		t.Errorf("suppressed messages = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(rateLimitedMessages.WithLabelValues(backendSNS, "rejecting", reasonDailyCap, "rejected")) - rejected; got != 2 {
+		t.Errorf("rejected messages = %v, want 2", got)
+	}
+
+	// Test that the summary waits for the bucket to be refilled
+	publishSummaries()
+	if got := testutil.ToFloat64(suppressionSummaries.WithLabelValues(backendSNS, "limited")) - summaries; got != 0 {
+		t.Fatalf("summaries = %v, want 0", got)
+	}
+
+	limitersMu.Lock()
+	l := limiters[routes[0].Targets[0].destination()]
+	l.filled = l.filled.Add(-time.Minute)
+	limitersMu.Unlock()
+
+	publishSummaries()
+	if got := testutil.ToFloat64(suppressionSummaries.WithLabelValues(backendSNS, "limited")) - summaries; got != 1 {
+		t.Errorf("summaries = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSNS, "limited")) - successful; got != 2 {
+		t.Errorf("successful requests = %v, want 2", got)
+	}
+	if l.suppressed != 0 {
+		t.Errorf("suppressed = %d after the summary, want 0", l.suppressed)
+	}
+}
+
+func TestRateLimitPerDestination(t *testing.T) {
+	routes := []*Route{
+		{
+			Receiver:  "admins",
+			RateLimit: &RateLimit{DailyCap: 1},
+			Targets: []*Target{
+				{SMS: &SMS{PhoneNumbers: []string{"+4915112345678"}}},
+				{SMS: &SMS{PhoneNumbers: []string{"+4915187654321"}}},
+				{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"},
+				{TopicARN: "arn:aws:sns:eu-west-1:123456789012:alerts"},
+			},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		limitersMu.Lock()
+		for _, target := range routes[0].Targets {
+			delete(limiters, target.destination())
+		}
+		limitersMu.Unlock()
+	}()
+
+	alerts := testAlerts(t)
+	for i, target := range routes[0].Targets {
+		if ok, _ := target.rateLimit(alerts, 1); !ok {
+			t.Errorf("target %d shares the daily cap of another target", i)
+		}
+	}
+
+	// Test that the suppressed notification is summarised to the
+	// recipients it was meant for
+	target := routes[0].Targets[1]
+	if ok, _ := target.rateLimit(alerts, 1); ok {
+		t.Fatal("notification over the daily cap was not suppressed")
+	}
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if l := limiters[target.destination()]; l == nil || l.target != target {
+		t.Error("suppressed notification was recorded for another target")
+	}
+	if l := limiters[routes[0].Targets[0].destination()]; l.suppressed != 0 {
+		t.Errorf("suppressed = %d for the other phone number, want 0", l.suppressed)
+	}
+}
+
+func TestSummaryInputs(t *testing.T) {
+	target := &Target{
+		SMS:       &SMS{PhoneNumbers: []string{"+4915112345678", "+4915187654321"}, MaxLength: 40},
+		RateLimit: &RateLimit{DailyCap: 10},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	message := summaryMessage(target.delivery(), 3, since)
+	inputs, err := target.summaryInputs(nil, testAlerts(t), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("%d summaries, want one per phone number", len(inputs))
+	}
+	if got := aws.StringValue(inputs[0].Message); !strings.HasPrefix(got, "3 notifications for sms") || len(got) > 40 {
+		t.Errorf("summary = %q", got)
+	}
+
+	lambdaTarget := &Target{Lambda: &Lambda{Function: "remediate"}}
+	if message := summaryMessage(lambdaTarget.delivery(), 3, since); !strings.HasPrefix(message, "{") {
+		t.Errorf("summary for Lambda = %q, want JSON", message)
+	}
+}
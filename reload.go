@@ -0,0 +1,105 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// reloadMu guards tmpH and routeConfig, which are swapped on reload
+	reloadMu sync.RWMutex
+
+	configLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "config",
+			Name:      "last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		},
+	)
+
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "config",
+			Name:      "last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		},
+	)
+)
+
+// reloadConfig parses the template and the config file and swaps them in
+// atomically. If either fails to parse, the previous ones stay in use.
+func reloadConfig() error {
+	var newTmpl *template.Template
+	var newConfig *Config
+	var err error
+
+	if templatePath != nil && *templatePath != "" {
+		newTmpl, err = parseTemplate(*templatePath)
+		if err != nil {
+			configLastReloadSuccessful.Set(0)
+			return err
+		}
+	}
+
+	if configFile != nil && *configFile != "" {
+		newConfig, err = loadConfig(*configFile)
+		if err != nil {
+			configLastReloadSuccessful.Set(0)
+			return err
+		}
+	}
+
+	reloadMu.Lock()
+	tmpH = newTmpl
+	routeConfig = newConfig
+	reloadMu.Unlock()
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+
+	return nil
+}
+
+// currentConfig returns the template and routing configuration in use
+func currentConfig() (*template.Template, *Config) {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+
+	return tmpH, routeConfig
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives
+// SIGHUP
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		log.Info("Received SIGHUP, reloading configuration")
+		if err := reloadConfig(); err != nil {
+			log.Errorf("Error reloading configuration, keeping the previous one: %v", err)
+			continue
+		}
+		log.Info("Configuration reloaded")
+	}
+}
+
+// Gin handler reloading the configuration
+func reloadPOSTHandler(c *gin.Context) {
+	if err := reloadConfig(); err != nil {
+		log.Errorf("Error reloading configuration, keeping the previous one: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reloaded": true})
+}
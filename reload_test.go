@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReloadConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("{{.Status}}")
+	file.Close()
+
+	templatePathTemp := file.Name()
+	templatePath = &templatePathTemp
+	defer func() {
+		templatePath = nil
+		tmpH = nil
+	}()
+
+	// Test that a valid template is loaded
+	req, _ := http.NewRequest("POST", "/-/reload", nil)
+	testHTTPResponse(t, r, req, http.StatusOK)
+
+	loaded, _ := currentConfig()
+	if loaded == nil {
+		t.Fatal("Template was not loaded")
+	}
+	if testutil.ToFloat64(configLastReloadSuccessful) != 1 {
+		t.Fatal("Successful reload was not reported")
+	}
+
+	// Test that a broken template keeps the previous one in use
+	ioutil.WriteFile(file.Name(), []byte("{{.Status"), 0600)
+
+	req, _ = http.NewRequest("POST", "/-/reload", nil)
+	testHTTPResponse(t, r, req, http.StatusInternalServerError)
+
+	current, _ := currentConfig()
+	if current != loaded {
+		t.Fatal("Template was replaced by a broken one")
+	}
+	if testutil.ToFloat64(configLastReloadSuccessful) != 0 {
+		t.Fatal("Failed reload was not reported")
+	}
+
+	// Test that a broken config file keeps the previous one in use
+	ioutil.WriteFile(file.Name(), []byte("{{.Status}}"), 0600)
+	configFileTemp := "testdata/missing.yml"
+	configFile = &configFileTemp
+	defer func() { configFile = nil }()
+
+	if err := reloadConfig(); err == nil {
+		t.Fatal("Missing config file was loaded successfully")
+	}
+	current, _ = currentConfig()
+	if current != loaded {
+		t.Fatal("Template was replaced although the config file is broken")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ready is cleared when the app starts shutting down, so it is taken out of
+// load balancing while in-flight notifications are published
+var ready int32 = 1
+
+// readyGETHandler reports whether the app accepts notifications
+func readyGETHandler(c *gin.Context) {
+	if atomic.LoadInt32(&ready) == 0 {
+		c.Writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+}
+
+// acceptWebhooks is a middleware rejecting notifications with 503 once the
+// app is shutting down, so Alertmanager retries them with another instance
+func acceptWebhooks(c *gin.Context) {
+	if atomic.LoadInt32(&ready) == 0 {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	c.Next()
+}
+
+// serve runs the server until it fails or the process receives SIGINT or
+// SIGTERM, then shuts down gracefully
+func serve(server *http.Server, listen func() error, stop chan struct{}, workers *sync.WaitGroup) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down", sig)
+	}
+
+	return shutdown(server, stop, workers, *shutdownDelay, *shutdownGracePeriod)
+}
+
+// shutdown stops accepting notifications and waits for the in-flight
+// requests to finish, then stops the background workers and publishes what
+// is buffered. The listener is kept open for the delay after readiness was
+// cleared, so load balancers stop sending requests before connections are
+// refused. All of it, the delay included, has to finish within the grace
+// period.
+func shutdown(server *http.Server, stop chan struct{}, workers *sync.WaitGroup, delay, gracePeriod time.Duration) error {
+	atomic.StoreInt32(&ready, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if delay > 0 {
+		log.Infof("Waiting %s before closing the listener", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("in-flight requests did not finish within %s: %v", gracePeriod, err)
+	}
+	log.Info("In-flight requests finished")
+
+	done := make(chan struct{})
+	go func() {
+		close(stop)
+		workers.Wait()
+
+		flushDigests()
+		for _, n := range notifiers {
+			if async, ok := n.(asyncNotifier); ok {
+				async.Flush()
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("Shutdown complete")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background work did not finish within %s", gracePeriod)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShutdown(t *testing.T) {
+	defer atomic.StoreInt32(&ready, 1)
+
+	svc = sns.New(mockJsonDataSession)
+	target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:shutdown", Digest: &Digest{Window: Duration(time.Hour)}}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+	digests := testutil.ToFloat64(digestsPublished.WithLabelValues(backendSNS, "shutdown"))
+	bufferDigest(target, testAlerts(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go server.Serve(listener)
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+	<-started
+
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		<-stop
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- shutdown(server, stop, &workers, 0, time.Second)
+	}()
+
+	// Test that the app is not ready while the request is in flight
+	if !waitFor(func() bool { return atomic.LoadInt32(&ready) == 0 }) {
+		t.Fatal("App was still ready after shutdown started")
+	}
+	req, _ := http.NewRequest("POST", "/alert/test-topic", nil)
+	testHTTPResponse(t, r, req, http.StatusServiceUnavailable)
+	req, _ = http.NewRequest("GET", "/ready", nil)
+	testHTTPResponse(t, r, req, http.StatusServiceUnavailable)
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if code := <-responses; code != http.StatusOK {
+		t.Errorf("In-flight request got %d, want %d", code, http.StatusOK)
+	}
+	if got := testutil.ToFloat64(digestsPublished.WithLabelValues(backendSNS, "shutdown")) - digests; got != 1 {
+		t.Errorf("digests = %v, want the buffered one published", got)
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	defer atomic.StoreInt32(&ready, 1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go server.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	// Test that a request outlasting the grace period fails the shutdown
+	if err := shutdown(server, make(chan struct{}), &sync.WaitGroup{}, 0, 50*time.Millisecond); err == nil {
+		t.Error("Shutdown did not fail after the grace period")
+	}
+}
+
+func TestShutdownDelay(t *testing.T) {
+	defer atomic.StoreInt32(&ready, 1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(listener)
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- shutdown(server, make(chan struct{}), &sync.WaitGroup{}, 200*time.Millisecond, time.Second)
+	}()
+
+	// Test that connections are accepted during the delay
+	if !waitFor(func() bool { return atomic.LoadInt32(&ready) == 0 }) {
+		t.Fatal("App was still ready after shutdown started")
+	}
+	resp, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Connection was refused during the delay: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("shutdown() returned after %s, before the delay", elapsed)
+	}
+
+	// Test that the delay is cut short by the grace period
+	atomic.StoreInt32(&ready, 1)
+	server = &http.Server{}
+	start = time.Now()
+	shutdown(server, make(chan struct{}), &sync.WaitGroup{}, time.Minute, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown() took %s, longer than the grace period", elapsed)
+	}
+}
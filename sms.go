@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	smsTypeAttribute     = "AWS.SNS.SMS.SMSType"
+	smsSenderIDAttribute = "AWS.SNS.SMS.SenderID"
+
+	smsTransactional = "Transactional"
+	smsPromotional   = "Promotional"
+
+	// defaultSMSMaxLength fits a single GSM encoded text message
+	defaultSMSMaxLength = 160
+	// minSMSMaxLength leaves room for a character and the ellipsis
+	minSMSMaxLength = 4
+
+	// smsMetricLabel is used as topic label of direct SMS metrics
+	smsMetricLabel = "sms"
+)
+
+var (
+	errNoPhoneNumbers = errors.New("no valid phone numbers to send the SMS to")
+
+	e164RE     = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	senderIDRE = regexp.MustCompile(`^[A-Za-z0-9]{1,11}$`)
+	lettersRE  = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// SMS configures a target publishing text messages directly to phone
+// numbers instead of to a topic
+type SMS struct {
+	PhoneNumbers          []string `yaml:"phone_numbers"`
+	PhoneNumberLabel      string   `yaml:"phone_number_label"`
+	PhoneNumberAnnotation string   `yaml:"phone_number_annotation"`
+	SMSType               string   `yaml:"sms_type"`
+	SenderID              string   `yaml:"sender_id"`
+	MaxLength             int      `yaml:"max_length"`
+}
+
+func (s *SMS) validate() error {
+	for _, number := range s.PhoneNumbers {
+		if !e164RE.MatchString(number) {
+			return fmt.Errorf("phone number %q is not in E.164 format", number)
+		}
+	}
+	if len(s.PhoneNumbers) == 0 && s.PhoneNumberLabel == "" && s.PhoneNumberAnnotation == "" {
+		return fmt.Errorf("sms needs phone_numbers, phone_number_label or phone_number_annotation")
+	}
+
+	switch s.SMSType {
+	case "":
+		s.SMSType = smsTransactional
+	case smsTransactional, smsPromotional:
+	default:
+		return fmt.Errorf("unsupported sms_type %q", s.SMSType)
+	}
+
+	// sender IDs are 1-11 alphanumeric characters with at least one letter
+	if s.SenderID != "" && (!senderIDRE.MatchString(s.SenderID) || !lettersRE.MatchString(s.SenderID)) {
+		return fmt.Errorf("invalid sender_id %q", s.SenderID)
+	}
+
+	if s.MaxLength == 0 {
+		s.MaxLength = defaultSMSMaxLength
+	}
+	if s.MaxLength < minSMSMaxLength {
+		return fmt.Errorf("max_length must be at least %d", minSMSMaxLength)
+	}
+
+	return nil
+}
+
+// phoneNumbers returns the static phone numbers and those taken from the
+// label or annotation of any of the alerts, skipping invalid ones
+func (s *SMS) phoneNumbers(alerts *Alerts) []string {
+	seen := make(map[string]bool)
+	var numbers []string
+
+	add := func(number string) {
+		number = strings.TrimSpace(number)
+		if number == "" || seen[number] {
+			return
+		}
+		if !e164RE.MatchString(number) {
+			log.Warnf("Skipping phone number %q, it is not in E.164 format", number)
+			return
+		}
+		seen[number] = true
+		numbers = append(numbers, number)
+	}
+
+	for _, number := range s.PhoneNumbers {
+		add(number)
+	}
+	for _, alert := range alerts.Alerts {
+		if s.PhoneNumberLabel != "" {
+			add(alert.Labels[s.PhoneNumberLabel])
+		}
+		if s.PhoneNumberAnnotation != "" {
+			add(alert.Annotations[s.PhoneNumberAnnotation])
+		}
+	}
+
+	sort.Strings(numbers)
+	return numbers
+}
+
+// smsInputs builds one publish request per phone number. The message is
+// rendered like a topic message and turned into plain text of at most
+// MaxLength characters. It fails if no valid phone number resolves, so the
+// notification is not silently acknowledged.
+func (t *Target) smsInputs(tmpl *template.Template, alerts Alerts, requestData []byte) ([]*sns.PublishInput, error) {
+	message := string(requestData)
+	var err error
+
+	switch {
+	case t.tmpl != nil:
+		message, err = renderTemplate(t.tmpl, alerts)
+	case tmpl != nil:
+		message, err = renderTemplate(tmpl, alerts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem with template execution: %v", err)
+	}
+	message = smsText(message, t.SMS.MaxLength)
+
+	attributes := map[string]*sns.MessageAttributeValue{
+		smsTypeAttribute: {
+			DataType:    aws.String(attributeString),
+			StringValue: aws.String(t.SMS.SMSType),
+		},
+	}
+	if t.SMS.SenderID != "" {
+		attributes[smsSenderIDAttribute] = &sns.MessageAttributeValue{
+			DataType:    aws.String(attributeString),
+			StringValue: aws.String(t.SMS.SenderID),
+		}
+	}
+
+	numbers := t.SMS.phoneNumbers(&alerts)
+	if len(numbers) == 0 {
+		return nil, errNoPhoneNumbers
+	}
+
+	inputs := make([]*sns.PublishInput, 0, len(numbers))
+	for _, number := range numbers {
+		inputs = append(inputs, &sns.PublishInput{
+			Message:           aws.String(message),
+			PhoneNumber:       aws.String(number),
+			MessageAttributes: attributes,
+		})
+	}
+
+	return inputs, nil
+}
+
+// smsText turns a rendered message into plain text: HTML entities are
+// unescaped, whitespace is collapsed and the text is truncated to
+// maxLength characters
+func smsText(message string, maxLength int) string {
+	text := strings.Join(strings.Fields(html.UnescapeString(message)), " ")
+
+	runes := []rune(text)
+	if len(runes) > maxLength {
+		text = string(runes[:maxLength-3]) + "..."
+	}
+
+	return text
+}
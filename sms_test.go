@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSMSValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		sms   SMS
+		valid bool
+	}{
+		{"Static numbers", SMS{PhoneNumbers: []string{"+4915112345678"}}, true},
+		{"Label", SMS{PhoneNumberLabel: "oncall_phone", SMSType: "Promotional", SenderID: "Alerts"}, true},
+		{"No numbers", SMS{}, false},
+		{"Invalid number", SMS{PhoneNumbers: []string{"015112345678"}}, false},
+		{"Unsupported type", SMS{PhoneNumberLabel: "phone", SMSType: "Urgent"}, false},
+		{"Numeric sender ID", SMS{PhoneNumberLabel: "phone", SenderID: "12345"}, false},
+		{"Long sender ID", SMS{PhoneNumberLabel: "phone", SenderID: "AlertmanagerSNS"}, false},
+		{"Negative max length", SMS{PhoneNumberLabel: "phone", MaxLength: -1}, false},
+		{"Short max length", SMS{PhoneNumberLabel: "phone", MaxLength: 3}, false},
+		{"Minimal max length", SMS{PhoneNumberLabel: "phone", MaxLength: 4}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sms.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSMSInputs(t *testing.T) {
+	alerts := testAlerts(t)
+	alerts.Alerts = append(alerts.Alerts, alerts.Alerts[0], alerts.Alerts[0])
+	alerts.Alerts[0].Labels = KV{"phone": "+4915112345678"}
+	alerts.Alerts[1].Labels = KV{"phone": "+4915112345678"}
+	alerts.Alerts[2].Labels = KV{"phone": "not a number"}
+
+	target := &Target{
+		Template: "testdata/sms.tmpl",
+		SMS: &SMS{
+			PhoneNumbers:     []string{"+12025550123"},
+			PhoneNumberLabel: "phone",
+			SenderID:         "Alerts",
+			MaxLength:        40,
+		},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+	if target.topicName() != smsMetricLabel {
+		t.Errorf("topicName() = %q, want %q", target.topicName(), smsMetricLabel)
+	}
+
+	inputs, err := target.publishInputs(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("got %d inputs, want 2", len(inputs))
+	}
+
+	for i, number := range []string{"+12025550123", "+4915112345678"} {
+		params := inputs[i]
+		if aws.StringValue(params.PhoneNumber) != number {
+			t.Errorf("input %d phone number = %q, want %q", i, aws.StringValue(params.PhoneNumber), number)
+		}
+		if params.TopicArn != nil {
+			t.Errorf("input %d has topic ARN %q", i, aws.StringValue(params.TopicArn))
+		}
+		if got := aws.StringValue(params.MessageAttributes[smsTypeAttribute].StringValue); got != smsTransactional {
+			t.Errorf("input %d SMS type = %q, want %q", i, got, smsTransactional)
+		}
+		if got := aws.StringValue(params.MessageAttributes[smsSenderIDAttribute].StringValue); got != "Alerts" {
+			t.Errorf("input %d sender ID = %q, want Alerts", i, got)
+		}
+		if message := aws.StringValue(params.Message); len([]rune(message)) > 40 || !strings.HasSuffix(message, "...") {
+			t.Errorf("input %d message %q is not truncated to 40 characters", i, message)
+		}
+	}
+}
+
+func TestSMSWithoutPhoneNumbers(t *testing.T) {
+	routes := []*Route{
+		{
+			Receiver: "admins",
+			Targets:  []*Target{{SMS: &SMS{PhoneNumberLabel: "oncall_phone"}}},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	routeConfig = &Config{Routes: routes}
+	defer func() { routeConfig = nil }()
+
+	// Test that the notification fails if no phone number resolves
+	before := testutil.ToFloat64(requestsUnsuccessful.WithLabelValues(backendSNS, smsMetricLabel))
+	req, _ := http.NewRequest("POST", "/alert", bytes.NewReader(data))
+	testHTTPResponse(t, r, req, http.StatusBadRequest)
+	if got := testutil.ToFloat64(requestsUnsuccessful.WithLabelValues(backendSNS, smsMetricLabel)) - before; got != 1 {
+		t.Errorf("unsuccessful requests = %v, want 1", got)
+	}
+}
+
+func TestSMSText(t *testing.T) {
+	got := smsText("[FIRING]\n  High load &amp; swap\n", 160)
+	if want := "[FIRING] High load & swap"; got != want {
+		t.Errorf("smsText() = %q, want %q", got, want)
+	}
+}
+
+func TestSMSTargetWithTopic(t *testing.T) {
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts",
+		SMS:      &SMS{PhoneNumberLabel: "phone"},
+	}
+	if err := target.init(); err == nil {
+		t.Error("init() accepted a target with topic_arn and sms")
+	}
+}
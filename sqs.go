@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsNotifier sends messages to SQS queues
+type sqsNotifier struct{}
+
+// sqsClient returns the SQS client for the key
+func sqsClient(key clientKey) *sqs.SQS {
+	return client(sqs.ServiceName, key, func(config *aws.Config) interface{} {
+		return sqs.New(awsSession, config)
+	}).(*sqs.SQS)
+}
+
+// Publish implements Notifier. SQS messages have no subject, so it is
+// dropped.
+func (sqsNotifier) Publish(d delivery, params *sns.PublishInput) error {
+	resp, err := sqsClient(d.Client).SendMessage(&sqs.SendMessageInput{
+		QueueUrl:               aws.String(d.Destination),
+		MessageBody:            params.Message,
+		MessageAttributes:      sqsAttributes(params.MessageAttributes),
+		MessageGroupId:         params.MessageGroupId,
+		MessageDeduplicationId: params.MessageDeduplicationId,
+	})
+	if err == nil {
+		log.Info(resp)
+	}
+	return err
+}
+
+// PublishBatch implements batchNotifier
+func (sqsNotifier) PublishBatch(d delivery, batch []*sns.PublishInput) ([]batchFailure, error) {
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(batch))
+	for i, params := range batch {
+		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            params.Message,
+			MessageAttributes:      sqsAttributes(params.MessageAttributes),
+			MessageGroupId:         params.MessageGroupId,
+			MessageDeduplicationId: params.MessageDeduplicationId,
+		})
+	}
+
+	resp, err := sqsClient(d.Client).SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(d.Destination),
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make([]batchFailure, 0, len(resp.Failed))
+	for _, entry := range resp.Failed {
+		failures = append(failures, newBatchFailure(entry.Id, entry.Code, entry.Message, entry.SenderFault, len(batch)))
+	}
+
+	return failures, nil
+}
+
+// StatusCode implements Notifier
+func (sqsNotifier) StatusCode(err error) int {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case sqs.ErrCodeQueueDoesNotExist, sqs.ErrCodeInvalidMessageContents,
+			sqs.ErrCodeUnsupportedOperation, sqs.ErrCodeInvalidAttributeName,
+			"InvalidParameterValue", "MissingParameter":
+			return http.StatusBadRequest
+		case "AccessDenied", "KMS.AccessDeniedException":
+			return http.StatusForbidden
+		}
+	}
+
+	return snsReturnCode(err)
+}
+
+// sqsAttributes converts SNS message attributes. SQS has no String.Array
+// type, those attributes are sent as String holding the JSON array.
+func sqsAttributes(attributes map[string]*sns.MessageAttributeValue) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	converted := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		dataType := aws.StringValue(value.DataType)
+		if dataType == attributeStringArray {
+			dataType = attributeString
+		}
+		converted[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String(dataType),
+			StringValue: value.StringValue,
+			BinaryValue: value.BinaryValue,
+		}
+	}
+
+	return converted
+}
+
+// validateQueueURL checks the URL of an SQS queue
+func validateQueueURL(queueURL string) error {
+	u, err := url.Parse(queueURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("invalid queue_url %q", queueURL)
+	}
+	return nil
+}
+
+// queueRegion returns the region of an SQS queue URL, empty if the URL
+// does not contain one, e.g. of an SQS compatible service
+func queueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(parts) > 2 && parts[0] == "sqs":
+		return parts[1]
+	case len(parts) > 2 && parts[1] == "queue":
+		return parts[0]
+	}
+	return ""
+}
+
+// isFIFOQueue returns whether the URL refers to a FIFO queue
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
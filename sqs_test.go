@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeMockSQSSession returns a Session whose SQS requests succeed, except
+// for the batch entry with the given ID, recording the request forms
+func makeMockSQSSession(failID string, forms *[]url.Values) *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*forms = append(*forms, r.PostForm)
+
+		if r.PostForm.Get("Action") == "SendMessage" {
+			fmt.Fprintf(w, "<SendMessageResponse><SendMessageResult><MessageId>message</MessageId><MD5OfMessageBody>%x</MD5OfMessageBody></SendMessageResult></SendMessageResponse>",
+				md5.Sum([]byte(r.PostForm.Get("MessageBody"))))
+			return
+		}
+
+		var successful, failed strings.Builder
+		for i := 1; r.PostForm.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			id := r.PostForm.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i))
+			body := r.PostForm.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.MessageBody", i))
+			if id == failID {
+				fmt.Fprintf(&failed, "<BatchResultErrorEntry><Id>%s</Id><Code>InvalidMessageContents</Code><Message>invalid message</Message><SenderFault>true</SenderFault></BatchResultErrorEntry>", id)
+				continue
+			}
+			fmt.Fprintf(&successful, "<SendMessageBatchResultEntry><Id>%s</Id><MessageId>message-%s</MessageId><MD5OfMessageBody>%x</MD5OfMessageBody></SendMessageBatchResultEntry>", id, id, md5.Sum([]byte(body)))
+		}
+		fmt.Fprintf(w, "<SendMessageBatchResponse><SendMessageBatchResult>%s%s</SendMessageBatchResult></SendMessageBatchResponse>", successful.String(), failed.String())
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	}))
+}
+
+func TestSQSTargetValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		valid  bool
+	}{
+		{"Queue", Target{QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts"}, true},
+		{"Queue and topic", Target{QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts", TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"}, false},
+		{"Message structure", Target{QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts", MessageStructure: map[string]string{"default": "raw"}}, false},
+		{"Failover", Target{QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts", Failover: []string{"eu-west-1"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	for _, queueURL := range []string{"sqs.eu-central-1.amazonaws.com/123456789012/alerts", "https://sqs.eu-central-1.amazonaws.com/"} {
+		if err := validateQueueURL(queueURL); err == nil {
+			t.Errorf("Invalid queue URL %q accepted", queueURL)
+		}
+	}
+}
+
+func TestQueueRegion(t *testing.T) {
+	tests := map[string]string{
+		"https://sqs.eu-west-1.amazonaws.com/123456789012/alerts":   "eu-west-1",
+		"https://eu-west-1.queue.amazonaws.com/123456789012/alerts": "eu-west-1",
+		"http://localhost:4566/000000000000/alerts":                 "",
+	}
+	for queueURL, want := range tests {
+		if got := queueRegion(queueURL); got != want {
+			t.Errorf("queueRegion(%q) = %q, want %q", queueURL, got, want)
+		}
+	}
+}
+
+func TestSQSPublish(t *testing.T) {
+	var forms []url.Values
+	awsSession = makeMockSQSSession("", &forms)
+	resetClients()
+
+	target := &Target{
+		QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts.fifo",
+		MessageAttributes: []*MessageAttribute{
+			{Name: "instances", Label: "instance", Type: "String.Array"},
+		},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := target.publishInputs(nil, testAlerts(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSQS, "alerts.fifo"))
+	if code := publish(target.delivery(), inputs[0], target.replicas()); code != http.StatusOK {
+		t.Fatalf("publish() = %d, want %d", code, http.StatusOK)
+	}
+
+	form := forms[0]
+	if form.Get("QueueUrl") != target.QueueURL || form.Get("MessageBody") != string(data) {
+		t.Errorf("Message was not sent to the queue: %v", form)
+	}
+	if form.Get("MessageGroupId") == "" || form.Get("MessageDeduplicationId") == "" {
+		t.Error("FIFO queue message has no group or deduplication ID")
+	}
+	if form.Get("MessageAttribute.1.Value.DataType") != "String" {
+		t.Errorf("String.Array attribute has type %q, want String", form.Get("MessageAttribute.1.Value.DataType"))
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendSQS, "alerts.fifo")) - before; got != 1 {
+		t.Errorf("successful requests = %v, want 1", got)
+	}
+}
+
+func TestSQSPublishAlerts(t *testing.T) {
+	var forms []url.Values
+	awsSession = makeMockSQSSession("1", &forms)
+	resetClients()
+
+	alerts := testAlerts(t)
+	alerts.Alerts = append(alerts.Alerts, alerts.Alerts[0], alerts.Alerts[0])
+	alerts.Alerts[1].Fingerprint = "failing"
+
+	target := &Target{
+		QueueURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/alerts",
+		PerAlert: true,
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, failed := publishAlerts(target, nil, alerts)
+	if len(forms) != 1 || forms[0].Get("Action") != "SendMessageBatch" {
+		t.Fatalf("Alerts were not sent in one batch: %v", forms)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if len(failed) != 1 || failed[0].Fingerprint != "failing" {
+		t.Errorf("failed alerts = %+v", failed)
+	}
+}
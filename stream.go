@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	backendKinesis  = "kinesis"
+	backendFirehose = "firehose"
+
+	defaultPartitionKey = "{{ .GroupKey }}"
+
+	// maxPartitionKeyLength is the length of partition keys Kinesis accepts
+	maxPartitionKeyLength = 256
+
+	// maxStreamBatchRecords is the number of records Kinesis and Firehose
+	// accept in a batch request
+	maxStreamBatchRecords = 500
+
+	// maxStreamBufferRecords is the number of records buffered per stream,
+	// further records are rejected until the buffer is flushed
+	maxStreamBufferRecords = 10000
+)
+
+var (
+	streamNameRE = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,128}$`)
+
+	errStreamBufferFull = errors.New("stream buffer is full")
+	errRecordTooLarge   = errors.New("record exceeds the maximum size of the stream")
+
+	streamBufferedRecords = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "stream",
+			Name:      "buffered_records",
+			Help:      "Number of records waiting to be put to a stream.",
+		},
+		labels,
+	)
+
+	streamDroppedRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "stream",
+			Name:      "dropped_records_total",
+			Help:      "Total number of records dropped after exhausting their put attempts.",
+		},
+		labels,
+	)
+)
+
+// Kinesis configures a target putting notifications to a Kinesis data
+// stream
+type Kinesis struct {
+	Stream       string `yaml:"stream"`
+	PartitionKey string `yaml:"partition_key"`
+}
+
+// Firehose configures a target putting notifications to a Firehose
+// delivery stream
+type Firehose struct {
+	DeliveryStream string `yaml:"delivery_stream"`
+}
+
+// archiveRecord is the normalized form of a notification put to streams
+type archiveRecord struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	Alerts
+}
+
+// archive delivers the notification to the archive targets. Failures are
+// logged and counted only: reporting them to Alertmanager would make it
+// retry the routed targets, which already succeeded.
+func archive(targets []*Target, tmpl *template.Template, alerts Alerts, requestData []byte) {
+	for _, target := range targets {
+		d := target.delivery()
+
+		inputs, err := target.publishInputs(tmpl, alerts, requestData)
+		if err != nil {
+			log.Errorf("Problem building archive record for %s %s: %v", d.backend(), d.Topic, err)
+			requestsUnsuccessful.WithLabelValues(d.backend(), d.Topic).Inc()
+			continue
+		}
+
+		for _, params := range inputs {
+			if err := d.publish(params); err != nil {
+				log.Warnf("Archiving to %s %s failed: %v", d.backend(), d.Topic, err)
+				requestsUnsuccessful.WithLabelValues(d.backend(), d.Topic).Inc()
+				continue
+			}
+			published(d, publishRegion(d.Client, params), 1)
+		}
+	}
+}
+
+// archiveMessage returns the normalized JSON form of the alerts
+func archiveMessage(alerts Alerts) (string, error) {
+	record, err := json.Marshal(archiveRecord{ReceivedAt: time.Now().UTC(), Alerts: alerts})
+	if err != nil {
+		return "", err
+	}
+	return string(record), nil
+}
+
+// initStream validates the stream options of the target
+func (t *Target) initStream() error {
+	if len(t.MessageAttributes) > 0 {
+		return fmt.Errorf("message_attributes are not supported for streams")
+	}
+	if t.Subject != "" {
+		return fmt.Errorf("subject is not supported for streams")
+	}
+	if t.Oversize != "" {
+		return fmt.Errorf("oversize is not supported for streams")
+	}
+
+	if t.Firehose != nil {
+		if !streamNameRE.MatchString(t.Firehose.DeliveryStream) {
+			return fmt.Errorf("invalid delivery_stream %q", t.Firehose.DeliveryStream)
+		}
+		return nil
+	}
+
+	if !streamNameRE.MatchString(t.Kinesis.Stream) {
+		return fmt.Errorf("invalid stream %q", t.Kinesis.Stream)
+	}
+	if t.Kinesis.PartitionKey == "" {
+		t.Kinesis.PartitionKey = defaultPartitionKey
+	}
+	tmpl, err := parseInlineTemplate("partition_key", t.Kinesis.PartitionKey)
+	if err != nil {
+		return err
+	}
+	t.partitionKeyTmpl = tmpl
+
+	return nil
+}
+
+// partitionKey renders the Kinesis partition key of the alerts. Keys
+// Kinesis does not accept are replaced by their hash.
+func (t *Target) partitionKey(alerts *Alerts) (string, error) {
+	key, err := executeInlineTemplate(t.partitionKeyTmpl, alerts)
+	if err != nil {
+		return "", err
+	}
+	if key == "" || len([]rune(key)) > maxPartitionKeyLength {
+		return hashID(key), nil
+	}
+	return key, nil
+}
+
+// streamRecord is a record waiting to be put to a stream
+type streamRecord struct {
+	data         []byte
+	partitionKey string
+	attempts     int
+}
+
+// streamNotifier buffers records and puts them to Kinesis or Firehose in
+// batches in the background. Records failing to be put are retried with
+// the next flush until they exhausted their attempts. Delivered records are
+// counted on flush.
+type streamNotifier struct {
+	backend        string
+	maxBatchBytes  int
+	maxRecordBytes int
+	newline        bool
+	put            func(d delivery, records []*streamRecord) ([]error, error)
+
+	mu      sync.Mutex
+	buffers map[delivery][]*streamRecord
+	start   sync.Once
+
+	// flushMu serializes flushes, so a flush on shutdown waits for a
+	// periodic one in progress
+	flushMu sync.Mutex
+}
+
+var (
+	kinesisNotifier = &streamNotifier{
+		backend:        backendKinesis,
+		maxBatchBytes:  5 * 1024 * 1024,
+		maxRecordBytes: 1024 * 1024,
+		put:            putKinesisRecords,
+	}
+
+	// records put to Firehose are newline delimited, so the objects it
+	// writes to S3 hold one JSON document per line
+	firehoseNotifier = &streamNotifier{
+		backend:        backendFirehose,
+		maxBatchBytes:  4 * 1024 * 1024,
+		maxRecordBytes: 1000 * 1024,
+		newline:        true,
+		put:            putFirehoseRecords,
+	}
+)
+
+// Publish implements Notifier. The message is buffered and put with the
+// next flush.
+func (n *streamNotifier) Publish(d delivery, params *sns.PublishInput) error {
+	data := []byte(aws.StringValue(params.Message))
+	if n.newline {
+		data = append(data, '\n')
+	}
+	if len(data)+len(aws.StringValue(params.MessageGroupId)) > n.maxRecordBytes {
+		return errRecordTooLarge
+	}
+
+	n.start.Do(func() {
+		go n.run(*streamFlushInterval)
+	})
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.buffers == nil {
+		n.buffers = make(map[delivery][]*streamRecord)
+	}
+	if len(n.buffers[d]) >= maxStreamBufferRecords {
+		return errStreamBufferFull
+	}
+
+	n.buffers[d] = append(n.buffers[d], &streamRecord{
+		data:         data,
+		partitionKey: aws.StringValue(params.MessageGroupId),
+	})
+	streamBufferedRecords.WithLabelValues(n.backend, d.Topic).Set(float64(len(n.buffers[d])))
+
+	return nil
+}
+
+// StatusCode implements Notifier
+func (n *streamNotifier) StatusCode(err error) int {
+	switch err {
+	case errRecordTooLarge:
+		return http.StatusBadRequest
+	case errStreamBufferFull:
+		return http.StatusServiceUnavailable
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case kinesis.ErrCodeResourceNotFoundException, kinesis.ErrCodeInvalidArgumentException:
+			return http.StatusBadRequest
+		case kinesis.ErrCodeKMSAccessDeniedException:
+			return http.StatusForbidden
+		}
+	}
+	return snsReturnCode(err)
+}
+
+// run flushes the buffers periodically
+func (n *streamNotifier) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		n.Flush()
+	}
+}
+
+// Flush puts all buffered records to their streams
+func (n *streamNotifier) Flush() {
+	n.flushMu.Lock()
+	defer n.flushMu.Unlock()
+
+	n.mu.Lock()
+	buffers := n.buffers
+	n.buffers = make(map[delivery][]*streamRecord)
+	n.mu.Unlock()
+
+	for d, records := range buffers {
+		retry := n.flush(d, records)
+
+		n.mu.Lock()
+		n.buffers[d] = append(retry, n.buffers[d]...)
+		streamBufferedRecords.WithLabelValues(n.backend, d.Topic).Set(float64(len(n.buffers[d])))
+		n.mu.Unlock()
+	}
+}
+
+// flush puts the records of a stream in batches and returns the records to
+// retry
+func (n *streamNotifier) flush(d delivery, records []*streamRecord) []*streamRecord {
+	var retry []*streamRecord
+
+	for len(records) > 0 {
+		size, count := 0, 0
+		for count < len(records) && count < maxStreamBatchRecords && size+len(records[count].data) <= n.maxBatchBytes {
+			size += len(records[count].data)
+			count++
+		}
+		batch := records[:count]
+		records = records[count:]
+
+		errs, err := n.put(d, batch)
+		if err != nil {
+			log.Warnf("Putting %d records to %s %s failed: %v", len(batch), n.backend, d.Topic, err)
+			errs = make([]error, len(batch))
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+
+		for i, record := range batch {
+			if i >= len(errs) || errs[i] == nil {
+				requestsSuccessful.WithLabelValues(n.backend, d.Topic).Inc()
+				continue
+			}
+
+			requestsUnsuccessful.WithLabelValues(n.backend, d.Topic).Inc()
+			record.attempts++
+			if record.attempts >= *streamMaxAttempts || n.StatusCode(errs[i]) < http.StatusInternalServerError {
+				log.Errorf("Dropping record for %s %s after %d attempts: %v", n.backend, d.Topic, record.attempts, errs[i])
+				streamDroppedRecords.WithLabelValues(n.backend, d.Topic).Inc()
+				continue
+			}
+			retry = append(retry, record)
+		}
+	}
+
+	return retry
+}
+
+// kinesisClient returns the Kinesis client for the key
+func kinesisClient(key clientKey) *kinesis.Kinesis {
+	return client(kinesis.ServiceName, key, func(config *aws.Config) interface{} {
+		return kinesis.New(awsSession, config)
+	}).(*kinesis.Kinesis)
+}
+
+// firehoseClient returns the Firehose client for the key
+func firehoseClient(key clientKey) *firehose.Firehose {
+	return client(firehose.ServiceName, key, func(config *aws.Config) interface{} {
+		return firehose.New(awsSession, config)
+	}).(*firehose.Firehose)
+}
+
+// putKinesisRecords puts records to a Kinesis data stream and returns the
+// error of every record, nil for those put successfully
+func putKinesisRecords(d delivery, records []*streamRecord) ([]error, error) {
+	entries := make([]*kinesis.PutRecordsRequestEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{
+			Data:         record.data,
+			PartitionKey: aws.String(record.partitionKey),
+		})
+	}
+
+	resp, err := kinesisClient(d.Client).PutRecords(&kinesis.PutRecordsInput{
+		StreamName: aws.String(d.Destination),
+		Records:    entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(records))
+	for i, entry := range resp.Records {
+		if entry.ErrorCode != nil && i < len(errs) {
+			errs[i] = awserr.New(aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage), nil)
+		}
+	}
+	return errs, nil
+}
+
+// putFirehoseRecords puts records to a Firehose delivery stream and
+// returns the error of every record, nil for those put successfully
+func putFirehoseRecords(d delivery, records []*streamRecord) ([]error, error) {
+	entries := make([]*firehose.Record, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, &firehose.Record{Data: record.data})
+	}
+
+	resp, err := firehoseClient(d.Client).PutRecordBatch(&firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(d.Destination),
+		Records:            entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(records))
+	for i, entry := range resp.RequestResponses {
+		if entry.ErrorCode != nil && i < len(errs) {
+			errs[i] = awserr.New(aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage), nil)
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeMockStreamSession returns a Session whose PutRecords and
+// PutRecordBatch requests fail with a throttling error for records whose
+// data contains failing, recording the requests
+func makeMockStreamSession(failing string, kinesisRequests *[]kinesis.PutRecordsInput, firehoseRequests *[]firehose.PutRecordBatchInput) *session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		fails := func(data []byte) bool {
+			return failing != "" && strings.Contains(string(data), failing)
+		}
+
+		if strings.HasPrefix(r.Header.Get("X-Amz-Target"), "Firehose") {
+			var input firehose.PutRecordBatchInput
+			json.Unmarshal(body, &input)
+			*firehoseRequests = append(*firehoseRequests, input)
+
+			result := firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(0)}
+			for i, record := range input.Records {
+				if fails(record.Data) {
+					*result.FailedPutCount++
+					result.RequestResponses = append(result.RequestResponses, &firehose.PutRecordBatchResponseEntry{
+						ErrorCode:    aws.String("ServiceUnavailableException"),
+						ErrorMessage: aws.String("slow down"),
+					})
+					continue
+				}
+				result.RequestResponses = append(result.RequestResponses, &firehose.PutRecordBatchResponseEntry{RecordId: aws.String(fmt.Sprint(i))})
+			}
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		var input kinesis.PutRecordsInput
+		json.Unmarshal(body, &input)
+		*kinesisRequests = append(*kinesisRequests, input)
+
+		result := kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+		for i, record := range input.Records {
+			if fails(record.Data) {
+				*result.FailedRecordCount++
+				result.Records = append(result.Records, &kinesis.PutRecordsResultEntry{
+					ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+					ErrorMessage: aws.String("rate exceeded"),
+				})
+				continue
+			}
+			result.Records = append(result.Records, &kinesis.PutRecordsResultEntry{
+				SequenceNumber: aws.String(fmt.Sprint(i)),
+				ShardId:        aws.String("shardId-000000000000"),
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+
+	return session.Must(session.NewSession(&aws.Config{
+		DisableSSL:  aws.Bool(true),
+		Endpoint:    aws.String(server.URL),
+		Region:      &regionString,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET_KEY", "TOKEN"),
+	}))
+}
+
+func TestStreamValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		valid  bool
+	}{
+		{"Kinesis", Target{Kinesis: &Kinesis{Stream: "alerts"}}, true},
+		{"Firehose", Target{Firehose: &Firehose{DeliveryStream: "alerts-archive"}}, true},
+		{"Partition key", Target{Kinesis: &Kinesis{Stream: "alerts", PartitionKey: `{{ .CommonLabels.alertname }}`}}, true},
+		{"Invalid partition key", Target{Kinesis: &Kinesis{Stream: "alerts", PartitionKey: `{{ .CommonLabels.alertname`}}, false},
+		{"Invalid stream", Target{Kinesis: &Kinesis{Stream: "alerts stream"}}, false},
+		{"Missing delivery stream", Target{Firehose: &Firehose{}}, false},
+		{"Subject", Target{Kinesis: &Kinesis{Stream: "alerts"}, Subject: "Alert"}, false},
+		{"Oversize", Target{Firehose: &Firehose{DeliveryStream: "alerts"}, Oversize: oversizeSplit}, false},
+		{"Stream and topic", Target{Kinesis: &Kinesis{Stream: "alerts"}, TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.init(); (err == nil) != tt.valid {
+				t.Errorf("init() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestArchiveMessage(t *testing.T) {
+	target := &Target{Kinesis: &Kinesis{Stream: "alerts", PartitionKey: `{{ .CommonLabels.alertname }}`}}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := testAlerts(t)
+	inputs, err := target.publishInputs(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var record archiveRecord
+	if err := json.Unmarshal([]byte(aws.StringValue(inputs[0].Message)), &record); err != nil {
+		t.Fatalf("Record is not JSON: %v", err)
+	}
+	if record.ReceivedAt.IsZero() || record.GroupKey != alerts.GroupKey || len(record.Alerts.Alerts) != len(alerts.Alerts) {
+		t.Errorf("Record does not hold the notification: %+v", record)
+	}
+	if got := aws.StringValue(inputs[0].MessageGroupId); got != alerts.CommonLabels["alertname"] {
+		t.Errorf("partition key = %q, want %q", got, alerts.CommonLabels["alertname"])
+	}
+
+	// Test that keys Kinesis does not accept are hashed
+	target.partitionKeyTmpl, _ = parseInlineTemplate("partition_key", strings.Repeat("x", maxPartitionKeyLength+1))
+	if key, _ := target.partitionKey(&alerts); len(key) > maxPartitionKeyLength {
+		t.Errorf("partitionKey() = %q, want a hash", key)
+	}
+}
+
+func TestKinesisFlush(t *testing.T) {
+	var requests []kinesis.PutRecordsInput
+	awsSession = makeMockStreamSession("retry", &requests, nil)
+	resetClients()
+
+	target := &Target{Kinesis: &Kinesis{Stream: "kinesis-flush"}}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+	d := target.delivery()
+
+	successful := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendKinesis, "kinesis-flush"))
+	dropped := testutil.ToFloat64(streamDroppedRecords.WithLabelValues(backendKinesis, "kinesis-flush"))
+	n := &streamNotifier{backend: backendKinesis, maxBatchBytes: 1024, maxRecordBytes: 512, put: putKinesisRecords}
+	for _, message := range []string{"first", "retry", "third"} {
+		if err := n.Publish(d, &sns.PublishInput{Message: aws.String(message), MessageGroupId: aws.String("key")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 0 {
+		t.Fatalf("Records were put before the flush")
+	}
+	n.Flush()
+
+	if len(requests) != 1 || len(requests[0].Records) != 3 || aws.StringValue(requests[0].StreamName) != "kinesis-flush" {
+		t.Fatalf("Records were not put in one batch: %+v", requests)
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendKinesis, "kinesis-flush")) - successful; got != 2 {
+		t.Errorf("successful requests = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(streamBufferedRecords.WithLabelValues(backendKinesis, "kinesis-flush")); got != 1 {
+		t.Errorf("buffered records = %v, want 1", got)
+	}
+
+	// Test that the throttled record is retried until it runs out of attempts
+	for i := 1; i < *streamMaxAttempts; i++ {
+		n.Flush()
+	}
+	if len(requests) != *streamMaxAttempts || len(requests[1].Records) != 1 || string(requests[1].Records[0].Data) != "retry" {
+		t.Errorf("Throttled record was not retried: %+v", requests)
+	}
+	if got := testutil.ToFloat64(streamDroppedRecords.WithLabelValues(backendKinesis, "kinesis-flush")) - dropped; got != 1 {
+		t.Errorf("dropped records = %v, want 1", got)
+	}
+	n.Flush()
+	if len(requests) != *streamMaxAttempts {
+		t.Errorf("Dropped record was put again")
+	}
+
+	// Test that records exceeding the maximum size are rejected
+	err := n.Publish(d, &sns.PublishInput{Message: aws.String(strings.Repeat("x", 513))})
+	if code := n.StatusCode(err); code != http.StatusBadRequest {
+		t.Errorf("StatusCode() = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestFirehoseFlush(t *testing.T) {
+	var requests []firehose.PutRecordBatchInput
+	awsSession = makeMockStreamSession("", nil, &requests)
+	resetClients()
+
+	target := &Target{Firehose: &Firehose{DeliveryStream: "firehose-flush"}}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendFirehose, "firehose-flush"))
+	n := &streamNotifier{backend: backendFirehose, maxBatchBytes: 16, maxRecordBytes: 16, newline: true, put: putFirehoseRecords}
+	for _, message := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := n.Publish(target.delivery(), &sns.PublishInput{Message: aws.String(message)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n.Flush()
+
+	// two records of 8 bytes fit into a batch of 16 bytes
+	if len(requests) != 2 || len(requests[0].Records) != 2 || len(requests[1].Records) != 1 {
+		t.Fatalf("Records were not batched by size: %+v", requests)
+	}
+	if got := string(requests[0].Records[0].Data); got != "{\"n\":1}\n" {
+		t.Errorf("record = %q, want newline delimited JSON", got)
+	}
+	if got := testutil.ToFloat64(requestsSuccessful.WithLabelValues(backendFirehose, "firehose-flush")) - before; got != 3 {
+		t.Errorf("successful requests = %v, want 3", got)
+	}
+}
+
+func TestArchiveFailureIgnored(t *testing.T) {
+	svc = sns.New(mockJsonDataSession)
+	n := &streamNotifier{backend: backendKinesis, maxBatchBytes: 1024, maxRecordBytes: 1, put: putKinesisRecords}
+	notifiers[backendKinesis] = n
+	defer func() { notifiers[backendKinesis] = kinesisNotifier }()
+
+	archiveTarget := &Target{Kinesis: &Kinesis{Stream: "archive-too-small"}}
+	if err := archiveTarget.init(); err != nil {
+		t.Fatal(err)
+	}
+	routes := []*Route{
+		{
+			Receiver: "admins",
+			Targets:  []*Target{{TopicARN: "arn:aws:sns:eu-central-1:123456789012:archived"}},
+		},
+	}
+	if err := routes[0].init(nil, "routes[0]"); err != nil {
+		t.Fatal(err)
+	}
+	routeConfig = &Config{Routes: routes, Archive: []*Target{archiveTarget}}
+	defer func() { routeConfig = nil }()
+
+	// Test that the rejected record does not fail the notification
+	before := testutil.ToFloat64(requestsUnsuccessful.WithLabelValues(backendKinesis, "archive-too-small"))
+	req, _ := http.NewRequest("POST", "/alert", strings.NewReader(string(data)))
+	testHTTPResponse(t, r, req, http.StatusOK)
+	if got := testutil.ToFloat64(requestsUnsuccessful.WithLabelValues(backendKinesis, "archive-too-small")) - before; got != 1 {
+		t.Errorf("unsuccessful requests = %v, want 1", got)
+	}
+}
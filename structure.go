@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+)
+
+// rawMessage configures a protocol to receive the webhook payload as is
+const rawMessage = "raw"
+
+// messageProtocols are the subscription protocols SNS accepts in a message
+// with MessageStructure json
+var messageProtocols = map[string]bool{
+	"default":     true,
+	"email":       true,
+	"email-json":  true,
+	"sms":         true,
+	"sqs":         true,
+	"lambda":      true,
+	"http":        true,
+	"https":       true,
+	"application": true,
+	"firehose":    true,
+}
+
+// initMessageStructure validates the protocols and parses their templates
+func (t *Target) initMessageStructure() error {
+	t.protocolTmpls = make(map[string]*template.Template)
+
+	for protocol, tmplPath := range t.MessageStructure {
+		if !messageProtocols[protocol] {
+			return fmt.Errorf("unsupported protocol %q in message_structure", protocol)
+		}
+		if tmplPath == rawMessage {
+			continue
+		}
+
+		tmpl, err := parseTemplate(tmplPath)
+		if err != nil {
+			return err
+		}
+		t.protocolTmpls[protocol] = tmpl
+	}
+
+	return nil
+}
+
+// structuredMessage assembles the JSON envelope with one message per
+// protocol. Protocols without template receive the default message, which
+// is the given message unless a default template is configured.
+func (t *Target) structuredMessage(message string, alerts Alerts, requestData []byte) (string, error) {
+	messages := map[string]string{"default": message}
+
+	for protocol, tmplPath := range t.MessageStructure {
+		if tmplPath == rawMessage {
+			messages[protocol] = string(requestData)
+			continue
+		}
+
+		rendered, err := renderTemplate(t.protocolTmpls[protocol], alerts)
+		if err != nil {
+			return "", fmt.Errorf("problem with %s template execution: %v", protocol, err)
+		}
+		messages[protocol] = rendered
+	}
+
+	envelope, err := json.Marshal(messages)
+	if err != nil {
+		return "", err
+	}
+
+	return string(envelope), nil
+}
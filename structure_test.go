@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestStructuredMessage(t *testing.T) {
+	alerts := testAlerts(t)
+
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts",
+		MessageStructure: map[string]string{
+			"sms": "testdata/sms.tmpl",
+			"sqs": "raw",
+		},
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := target.publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.MessageStructure) != "json" {
+		t.Fatalf("MessageStructure = %q", aws.StringValue(params.MessageStructure))
+	}
+
+	var messages map[string]string
+	if err := json.Unmarshal([]byte(aws.StringValue(params.Message)), &messages); err != nil {
+		t.Fatalf("Message is not a JSON envelope: %v", err)
+	}
+	if messages["default"] != string(data) || messages["sqs"] != string(data) {
+		t.Errorf("Default or raw message = %v", messages)
+	}
+	if messages["sms"] != "[firing] something_happend: runit service prometheus_bot restarted, server01.int:9100\n" {
+		t.Errorf("SMS message = %q", messages["sms"])
+	}
+}
+
+func TestMessageStructureValidation(t *testing.T) {
+	invalid := []map[string]string{
+		{"carrier-pigeon": "testdata/sms.tmpl"},
+		{"sms": "testdata/missing.tmpl"},
+	}
+	for _, structure := range invalid {
+		target := &Target{TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts", MessageStructure: structure}
+		if err := target.init(); err == nil {
+			t.Errorf("Invalid message structure was accepted: %v", structure)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	texttemplate "text/template"
+	"unicode"
+)
+
+// maxSubjectLength is the length SNS subjects must stay below
+const maxSubjectLength = 100
+
+// subject renders the subject of the target, falling back to the global
+// one. It returns nil if there is no subject or it renders empty.
+func (t *Target) subject(alerts *Alerts) (*string, error) {
+	tmpl := t.subjectTmpl
+	if tmpl == nil {
+		tmpl = subjectTmpl
+	}
+	if tmpl == nil {
+		return nil, nil
+	}
+
+	subject, err := executeInlineTemplate(tmpl, alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	subject = sanitizeSubject(subject)
+	if subject == "" {
+		return nil, nil
+	}
+
+	return &subject, nil
+}
+
+// transliterations spells common Latin letters in ASCII, as SNS subjects
+// must be printable ASCII
+var transliterations = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "Ae", 'Å': "A", 'Æ': "AE",
+	'Ç': "C", 'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I",
+	'Î': "I", 'Ï': "I", 'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O",
+	'Õ': "O", 'Ö': "Oe", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "Ue",
+	'Ý': "Y", 'Þ': "Th", 'ß': "ss", 'à': "a", 'á': "a", 'â': "a", 'ã': "a",
+	'ä': "ae", 'å': "a", 'æ': "ae", 'ç': "c", 'è': "e", 'é': "e", 'ê': "e",
+	'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ð': "d", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "oe", 'ø': "o", 'ù': "u",
+	'ú': "u", 'û': "u", 'ü': "ue", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'–': "-", '—': "-", '‘': "'", '’': "'", '“': "\"", '”': "\"", '…': "...",
+}
+
+// sanitizeSubject makes a subject acceptable to SNS: line breaks and other
+// whitespace are collapsed into single spaces, common Latin letters are
+// transliterated, all other characters outside printable ASCII are
+// stripped and the result is truncated below maxSubjectLength characters
+func sanitizeSubject(subject string) string {
+	var b strings.Builder
+	space := false
+
+	for _, r := range subject {
+		text := string(r)
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case transliterations[r] != "":
+			text = transliterations[r]
+		case r < ' ' || r > '~':
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(text)
+	}
+
+	runes := []rune(b.String())
+	if len(runes) >= maxSubjectLength {
+		runes = append(runes[:maxSubjectLength-4], []rune("...")...)
+	}
+
+	return string(runes)
+}
+
+// parseSubject parses the global subject given as argument
+func parseSubject(subject string) (*texttemplate.Template, error) {
+	if subject == "" {
+		return nil, nil
+	}
+	return parseInlineTemplate("subject", subject)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSanitizeSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{"Plain", "Alert firing", "Alert firing"},
+		{"Line breaks", "Alert\nfiring\r\n on  host", "Alert firing on host"},
+		{"Leading whitespace", "\n\t Alert", "Alert"},
+		{"Control characters", "Alert\x00\x07 firing\x7f", "Alert firing"},
+		{"Non-ASCII", "Alert für Störung", "Alert fuer Stoerung"},
+		{"Emoji", "🔥 Disk füll", "Disk fuell"},
+		{"Other scripts", "Alert 障害 firing", "Alert firing"},
+		{"Empty", " \n ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeSubject(tt.subject); got != tt.want {
+				t.Errorf("sanitizeSubject() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Test that long subjects are truncated below the SNS limit
+	long := sanitizeSubject(strings.Repeat("ä", 150))
+	if utf8.RuneCountInString(long) >= maxSubjectLength || !strings.HasSuffix(long, "...") {
+		t.Errorf("sanitizeSubject() = %q", long)
+	}
+}
+
+func TestTemplatedSubject(t *testing.T) {
+	alerts := testAlerts(t)
+
+	target := &Target{
+		TopicARN: "arn:aws:sns:eu-central-1:123456789012:alerts",
+		Subject:  "[{{ .Status | upper }}] {{ .CommonLabels.alertname }}\n",
+	}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := target.publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.Subject) != "[FIRING] something_happend" {
+		t.Errorf("Subject = %q", aws.StringValue(params.Subject))
+	}
+
+	// Test that targets without subject use the global one
+	subjectTmpl, _ = parseSubject("{{ .Receiver }}")
+	defer func() { subjectTmpl = nil }()
+
+	params, err = (&Target{TopicARN: target.TopicARN}).publishInput(nil, alerts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(params.Subject) != "admins" {
+		t.Errorf("Subject = %q", aws.StringValue(params.Subject))
+	}
+
+	// Test that a subject rendering empty is omitted
+	subjectTmpl, _ = parseSubject("{{ .CommonLabels.missing }}")
+	params, _ = (&Target{TopicARN: target.TopicARN}).publishInput(nil, alerts, data)
+	if params.Subject != nil {
+		t.Errorf("Subject = %q, want none", aws.StringValue(params.Subject))
+	}
+}
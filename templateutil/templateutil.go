@@ -211,8 +211,8 @@ func StrFormatFloat(f string) string {
 	return strconv.FormatFloat(v, 'f', -1, 64)
 }
 
-// StrFormatDate formats as date
-func StrFormatDate(toformat string, templateTimeZone string, templateTimeOutFormat string) string {
+// StrFormatDate formats as date, accepting either a time.Time or an RFC3339 string
+func StrFormatDate(toformat interface{}, templateTimeZone string, templateTimeOutFormat string) string {
 
 	// Error handling
 	if templateTimeZone == "" {
@@ -225,10 +225,22 @@ func StrFormatDate(toformat string, templateTimeZone string, templateTimeOutForm
 		panic(nil)
 	}
 
-	t, err := time.Parse(time.RFC3339Nano, toformat)
+	var t time.Time
 
-	if err != nil {
-		fmt.Println(err)
+	switch v := toformat.(type) {
+	case time.Time:
+		t = v
+	case string:
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			fmt.Println(err)
+		}
+	default:
+		// anything else has no date to format, rendering the zero time would
+		// pass for a real one
+		log.Printf("Cannot format %T as date", toformat)
+		return ""
 	}
 
 	loc, _ := time.LoadLocation(templateTimeZone)
@@ -237,7 +249,7 @@ func StrFormatDate(toformat string, templateTimeZone string, templateTimeOutForm
 }
 
 // HasKey checks if the map contains the key
-func HasKey(dict map[string]interface{}, keySearch string) bool {
+func HasKey(dict map[string]string, keySearch string) bool {
 	if _, ok := dict[keySearch]; ok {
 		return true
 	}
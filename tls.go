@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tlsReloader serves the certificate and client CA bundle of the listener.
+// The files are loaded again when they change, so certificates rotated by
+// e.g. cert-manager are picked up without restart. If loading fails, the
+// previous certificate stays in use.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// newTLSReloader loads the certificate, key and optional client CA bundle
+func newTLSReloader(certFile, keyFile, clientCAFile string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a key file are needed for TLS")
+	}
+
+	r := &tlsReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the files if any of them changed since they were loaded
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var modTimes [3]time.Time
+	for i, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if r.cert != nil && modTimes == r.modTimes {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %v", err)
+	}
+
+	var clientCA *x509.CertPool
+	if r.clientCAFile != "" {
+		data, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.clientCAFile)
+		}
+	}
+
+	if r.cert != nil {
+		log.Infof("Reloaded TLS certificate %s", r.certFile)
+	}
+	r.cert, r.clientCA, r.modTimes = &cert, clientCA, modTimes
+
+	return nil
+}
+
+// current returns the certificate and client CA bundle, reloading them if
+// they changed
+func (r *tlsReloader) current() (*tls.Certificate, *x509.CertPool) {
+	if err := r.reload(); err != nil {
+		log.Errorf("Problem reloading TLS certificate, keeping the previous one: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.clientCA
+}
+
+// config returns the TLS configuration of the listener. Client
+// certificates are verified if there is a client CA bundle, but only
+// required by verifyClientCert, so probes and Prometheus can connect
+// without one.
+func (r *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCA := r.current()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCA != nil {
+				config.ClientCAs = clientCA
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
+
+// verifyClientCert is a middleware rejecting requests without a client
+// certificate verified against the client CA bundle, if there is one
+func verifyClientCert(c *gin.Context) {
+	if *tlsClientCAFile == "" || (c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0) {
+		c.Next()
+		return
+	}
+
+	unauthorizedRequests.WithLabelValues(reasonMissingClientCert).Inc()
+	log.Warnf("Rejecting request to %s from %s: missing client certificate", c.Request.URL.Path, c.ClientIP())
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeTestCert writes a certificate signed by the parent, self-signed if
+// there is none, and its key to the directory
+func writeTestCert(t *testing.T, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCert(t, dir, "ca", 1, nil, nil)
+	writeTestCert(t, dir, "server", 2, ca, caKey)
+	writeTestCert(t, dir, "client", 3, ca, caKey)
+
+	reloader, err := newTLSReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(dir, "ca.crt")
+	tlsClientCAFile = &caFile
+	defer func() {
+		noCAFile := ""
+		tlsClientCAFile = &noCAFile
+	}()
+
+	router := gin.New()
+	router.GET("/health", healthGETHandler)
+	router.GET("/alert", verifyClientCert, healthGETHandler)
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = reloader.config()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getPath := func(certs []tls.Certificate, path string) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			ServerName:   "localhost",
+		}}}
+		return client.Get(server.URL + path)
+	}
+	get := func(certs []tls.Certificate) (*http.Response, error) {
+		return getPath(certs, "/alert")
+	}
+
+	resp, err := get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("server certificate serial = %d, want 2", serial)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Client with certificate got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Test that clients without certificate are rejected, except by the
+	// probes
+	resp, err = get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Client without certificate got %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	resp, err = getPath(nil, "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Probe without certificate got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Test that a rotated certificate is served without restart
+	writeTestCert(t, dir, "server", 4, ca, caKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), later, later)
+	os.Chtimes(filepath.Join(dir, "server.key"), later, later)
+
+	resp, err = get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("server certificate serial = %d, want the rotated 4", serial)
+	}
+
+	// Test that a broken certificate keeps the previous one in use
+	ioutil.WriteFile(filepath.Join(dir, "server.crt"), []byte("broken"), 0600)
+	if _, err := get([]tls.Certificate{clientCert}); err != nil {
+		t.Errorf("Previous certificate was not kept: %v", err)
+	}
+
+	if _, err := newTLSReloader(filepath.Join(dir, "server.crt"), "", ""); err == nil {
+		t.Error("Certificate without key was accepted")
+	}
+}
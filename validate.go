@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// supportedVersion is the Alertmanager webhook payload version understood
+const supportedVersion = "4"
+
+// Reasons a payload is rejected for, used as metric label
+const (
+	reasonReadError          = "read_error"
+	reasonBodyTooLarge       = "body_too_large"
+	reasonInvalidJSON        = "invalid_json"
+	reasonUnsupportedVersion = "unsupported_version"
+	reasonMissingField       = "missing_field"
+	reasonInvalidStatus      = "invalid_status"
+	reasonNoAlerts           = "no_alerts"
+)
+
+var (
+	invalidPayloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "invalid_payloads_total",
+			Help:      "Total number of webhook payloads rejected as invalid.",
+		},
+		[]string{"reason"},
+	)
+)
+
+// payloadError describes why a webhook payload was rejected
+type payloadError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"error"`
+}
+
+func (e *payloadError) Error() string {
+	return e.Message
+}
+
+func newPayloadError(reason string, format string, args ...interface{}) *payloadError {
+	return &payloadError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// readAlerts reads at most maxSize bytes of the request body and parses and
+// validates them as Alertmanager webhook payload
+func readAlerts(body io.Reader, maxSize int64) ([]byte, Alerts, *payloadError) {
+	var alerts Alerts
+
+	requestData, err := ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, alerts, newPayloadError(reasonReadError, "cannot read request body: %v", err)
+	}
+	if int64(len(requestData)) > maxSize {
+		return nil, alerts, newPayloadError(reasonBodyTooLarge, "request body exceeds %d bytes", maxSize)
+	}
+
+	if err := json.Unmarshal(requestData, &alerts); err != nil {
+		return nil, alerts, newPayloadError(reasonInvalidJSON, "cannot parse request body: %v", err)
+	}
+
+	if perr := validateAlerts(&alerts); perr != nil {
+		return nil, alerts, perr
+	}
+
+	return requestData, alerts, nil
+}
+
+// validateAlerts checks that all fields required to process the payload
+// are present and valid
+func validateAlerts(alerts *Alerts) *payloadError {
+	if alerts.Version != supportedVersion {
+		return newPayloadError(reasonUnsupportedVersion, "unsupported version %q, expected %q", alerts.Version, supportedVersion)
+	}
+	if alerts.Receiver == "" {
+		return newPayloadError(reasonMissingField, "receiver is required")
+	}
+	if alerts.GroupKey == "" {
+		return newPayloadError(reasonMissingField, "groupKey is required")
+	}
+	if !validStatus(alerts.Status) {
+		return newPayloadError(reasonInvalidStatus, "status must be %q or %q, got %q", AlertFiring, AlertResolved, alerts.Status)
+	}
+	if len(alerts.Alerts) == 0 {
+		return newPayloadError(reasonNoAlerts, "alerts must not be empty")
+	}
+
+	for i, alert := range alerts.Alerts {
+		if !validStatus(alert.Status) {
+			return newPayloadError(reasonInvalidStatus, "alerts[%d].status must be %q or %q, got %q", i, AlertFiring, AlertResolved, alert.Status)
+		}
+		if len(alert.Labels) == 0 {
+			return newPayloadError(reasonMissingField, "alerts[%d].labels is required", i)
+		}
+		if alert.StartsAt.IsZero() {
+			return newPayloadError(reasonMissingField, "alerts[%d].startsAt is required", i)
+		}
+	}
+
+	return nil
+}
+
+func validStatus(status string) bool {
+	return status == AlertFiring || status == AlertResolved
+}
+
+// rejectPayload responds with 400 and a JSON body describing the error
+func rejectPayload(c *gin.Context, perr *payloadError) {
+	invalidPayloads.WithLabelValues(perr.Reason).Inc()
+	log.Warnf("Rejecting invalid payload: %s", perr.Message)
+	c.JSON(http.StatusBadRequest, perr)
+}
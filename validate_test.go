@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateAlerts(t *testing.T) {
+	valid := func() Alerts {
+		var alerts Alerts
+		if err := json.Unmarshal(data, &alerts); err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Alerts)
+		want   string
+	}{
+		{"Valid", func(*Alerts) {}, ""},
+		{"Unsupported version", func(a *Alerts) { a.Version = "3" }, reasonUnsupportedVersion},
+		{"Missing receiver", func(a *Alerts) { a.Receiver = "" }, reasonMissingField},
+		{"Missing group key", func(a *Alerts) { a.GroupKey = "" }, reasonMissingField},
+		{"Invalid status", func(a *Alerts) { a.Status = "pending" }, reasonInvalidStatus},
+		{"No alerts", func(a *Alerts) { a.Alerts = nil }, reasonNoAlerts},
+		{"Invalid alert status", func(a *Alerts) { a.Alerts[0].Status = "" }, reasonInvalidStatus},
+		{"Missing alert labels", func(a *Alerts) { a.Alerts[0].Labels = nil }, reasonMissingField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := valid()
+			tt.modify(&alerts)
+
+			perr := validateAlerts(&alerts)
+			switch {
+			case perr == nil && tt.want != "":
+				t.Errorf("validateAlerts() = nil, want %s", tt.want)
+			case perr != nil && perr.Reason != tt.want:
+				t.Errorf("validateAlerts() = %s (%s), want %s", perr.Reason, perr.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAlertsBodySize(t *testing.T) {
+	if _, _, perr := readAlerts(bytes.NewReader(data), int64(len(data))); perr != nil {
+		t.Fatalf("Payload of maximum size was rejected: %v", perr)
+	}
+
+	_, _, perr := readAlerts(bytes.NewReader(data), int64(len(data)-1))
+	if perr == nil || perr.Reason != reasonBodyTooLarge {
+		t.Fatalf("Oversized payload was not rejected: %v", perr)
+	}
+}
+
+func TestSNSAlertEndpointRejectsInvalidPayload(t *testing.T) {
+	before := testutil.ToFloat64(invalidPayloads.WithLabelValues(reasonInvalidJSON))
+
+	req, _ := http.NewRequest("POST", "/alert/test-topic", strings.NewReader("test-payload"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid payload returned status %d", w.Code)
+	}
+
+	var body payloadError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error body is not JSON: %s", w.Body.String())
+	}
+	if body.Reason != reasonInvalidJSON || body.Message == "" {
+		t.Fatalf("Error body does not describe the failure: %s", w.Body.String())
+	}
+
+	if testutil.ToFloat64(invalidPayloads.WithLabelValues(reasonInvalidJSON)) != before+1 {
+		t.Fatal("Invalid payload was not counted")
+	}
+}